@@ -0,0 +1,111 @@
+package nradix
+
+import (
+	"math/big"
+	"net/netip"
+)
+
+// Monoid describes a per-subtree aggregate that the tree keeps up to date as prefixes change.
+// The tree caches the aggregate of every node's subtree, so updates cost O(depth) and queries O(depth).
+type Monoid interface {
+	// Identity returns the aggregate of an empty subtree.
+	Identity() interface{}
+	// Measure returns the aggregate of a single stored prefix.
+	Measure(prefix netip.Prefix, value interface{}) interface{}
+	// Combine merges two aggregates. It must be associative and must not modify its arguments.
+	Combine(a, b interface{}) interface{}
+}
+
+// SetAggregator augments every node with the aggregate of its subtree under m and keeps it up to date
+// on insert and delete. Passing nil stops maintaining aggregates. Expired TTL entries are counted until swept.
+func (tree *Tree) SetAggregator(m Monoid) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	tree.monoid = m
+	if m == nil {
+		tree.aggs = nil
+		return
+	}
+	tree.aggs = make(map[*Node]interface{})
+	tree.aggregateSubtree(tree.root)
+}
+
+// Aggregate returns the aggregate of the prefixes stored inside prefix, including prefix itself.
+// It returns nil if no aggregator has been set.
+func (tree *Tree) Aggregate(prefix netip.Prefix) interface{} {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	if tree.monoid == nil {
+		return nil
+	}
+	key, mask := prefixKeyMask(prefix)
+	if node := tree.lookup6(key, mask); node != nil {
+		return tree.aggs[node]
+	}
+	return tree.monoid.Identity()
+}
+
+// aggregateSubtree recomputes the aggregates of n and everything below it.
+func (tree *Tree) aggregateSubtree(n *Node) {
+	if n.left != nil {
+		tree.aggregateSubtree(n.left)
+	}
+	if n.right != nil {
+		tree.aggregateSubtree(n.right)
+	}
+	tree.aggs[n] = tree.aggregateNode(n)
+}
+
+// aggregateNode computes the aggregate of n from its own value and the cached aggregates of its children.
+func (tree *Tree) aggregateNode(n *Node) interface{} {
+	agg := tree.monoid.Identity()
+	if n.value != nil {
+		agg = tree.monoid.Measure(userPrefix(n.prefix), n.value)
+	}
+	if n.left != nil {
+		agg = tree.monoid.Combine(agg, tree.aggs[n.left])
+	}
+	if n.right != nil {
+		agg = tree.monoid.Combine(agg, tree.aggs[n.right])
+	}
+	return agg
+}
+
+// reaggregate refreshes the aggregates from n up to the root after n or its children changed.
+func (tree *Tree) reaggregate(n *Node) {
+	if tree.monoid == nil {
+		return
+	}
+	for ; n != nil; n = n.parent {
+		tree.aggs[n] = tree.aggregateNode(n)
+	}
+}
+
+// CountMonoid counts stored prefixes, its aggregates are ints.
+type CountMonoid struct{}
+
+func (CountMonoid) Identity() interface{}                         { return 0 }
+func (CountMonoid) Measure(netip.Prefix, interface{}) interface{} { return 1 }
+func (CountMonoid) Combine(a, b interface{}) interface{}          { return a.(int) + b.(int) }
+
+// AddressMonoid sums the number of addresses of the stored prefixes, its aggregates are *big.Int.
+// Nested prefixes are each counted in full; see CoverageV4 and CoverageV6 for counts without overlaps.
+type AddressMonoid struct{}
+
+func (AddressMonoid) Identity() interface{} { return new(big.Int) }
+
+func (AddressMonoid) Measure(prefix netip.Prefix, _ interface{}) interface{} {
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+}
+
+func (AddressMonoid) Combine(a, b interface{}) interface{} {
+	return new(big.Int).Add(a.(*big.Int), b.(*big.Int))
+}
+
+// SumMonoid sums a numeric field extracted from stored values, its aggregates are float64.
+type SumMonoid func(value interface{}) float64
+
+func (SumMonoid) Identity() interface{}                                   { return float64(0) }
+func (f SumMonoid) Measure(_ netip.Prefix, value interface{}) interface{} { return f(value) }
+func (SumMonoid) Combine(a, b interface{}) interface{}                    { return a.(float64) + b.(float64) }
@@ -0,0 +1,76 @@
+package nradix
+
+import (
+	"math/big"
+	"net/netip"
+	"testing"
+)
+
+// TestAggregate tests that subtree aggregates follow inserts and deletes.
+func TestAggregate(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 5.0, false)
+	tr.SetAggregator(CountMonoid{})
+
+	tr.SetCIDRString("10.1.0.0/16", 1.0, false)
+	tr.SetCIDRString("10.1.2.0/24", 2.0, false)
+	tr.SetCIDRString("192.168.0.0/16", 3.0, false)
+	tr.SetCIDRString("2001:db8::/32", 4.0, false)
+
+	for _, tc := range []struct {
+		prefix string
+		want   int
+	}{
+		{"::/0", 5},
+		{"0.0.0.0/0", 4},
+		{"10.0.0.0/8", 3},
+		{"10.1.0.0/16", 2},
+		{"10.2.0.0/16", 0},
+		{"2001:db8::/16", 1},
+	} {
+		if got := tr.Aggregate(netip.MustParsePrefix(tc.prefix)); got != tc.want {
+			t.Errorf("Aggregate(%s): expected %d, got %v", tc.prefix, tc.want, got)
+		}
+	}
+
+	tr.DeleteCIDRString("10.1.0.0/16")
+	tr.DeleteWholeRangeCIDR("192.168.0.0/16")
+	if got := tr.Aggregate(netip.MustParsePrefix("0.0.0.0/0")); got != 2 {
+		t.Errorf("Expected 2 IPv4 prefixes after deletes, got %v", got)
+	}
+
+	tr.SetAggregator(SumMonoid(func(value interface{}) float64 { return value.(float64) }))
+	if got := tr.Aggregate(netip.MustParsePrefix("::/0")); got != 11.0 {
+		t.Errorf("Expected sum 11, got %v", got)
+	}
+
+	tr.SetAggregator(AddressMonoid{})
+	if got := tr.Aggregate(netip.MustParsePrefix("10.1.0.0/16")).(*big.Int); got.Int64() != 256 {
+		t.Errorf("Expected 256 addresses, got %v", got)
+	}
+}
+
+// TestAggregateSideTable tests that aggregates are only kept once SetAggregator is used and not for freed nodes.
+func TestAggregateSideTable(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	if tr.aggs != nil {
+		t.Fatal("Expected no aggregates without an aggregator")
+	}
+
+	tr.SetAggregator(CountMonoid{})
+	tr.SetCIDRString("10.1.0.0/16", 2, false)
+	tr.SetCIDRString("10.1.2.0/24", 3, false)
+	tr.DeleteWholeRangeCIDR("10.1.0.0/16")
+
+	nodes := 0
+	eachNode(tr.root, func(*Node) { nodes++ })
+	if len(tr.aggs) != nodes {
+		t.Errorf("Expected an aggregate for each of the %d nodes, got %d", nodes, len(tr.aggs))
+	}
+
+	tr.SetAggregator(nil)
+	if tr.aggs != nil {
+		t.Error("Expected the aggregates to be dropped with the aggregator")
+	}
+}
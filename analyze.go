@@ -0,0 +1,103 @@
+package nradix
+
+import (
+	"net/netip"
+)
+
+// Finding describes one stored prefix flagged by Analyze.
+type Finding struct {
+	Prefix        netip.Prefix
+	Value         interface{}
+	Covering      netip.Prefix // nearest covering stored prefix of the same family, invalid if none
+	CoveringValue interface{}
+	By            []netip.Prefix // for shadowed prefixes, the more-specific prefixes that cover it
+}
+
+// AnalysisReport lists the overlap problems found by Analyze, each list in the tree's bit order.
+type AnalysisReport struct {
+	// Shadowed prefixes are fully covered by more-specific prefixes, at least one with a different value,
+	// so no lookup ever returns their value.
+	Shadowed []Finding
+	// Redundant prefixes hold the same value as their covering prefix and could be removed without changing any lookup.
+	Redundant []Finding
+	// Hidden prefixes hold a different value than their covering prefix. Longest-prefix match honours them,
+	// but a first-match consumer of the same rules would never reach them behind Covering.
+	Hidden []Finding
+}
+
+// Analyze inspects the parent/child relationships of the stored prefixes and reports shadowed,
+// redundant and hidden entries. IPv4 prefixes are only compared with other IPv4 prefixes, since
+// IPv4 lookups ignore anything stored above ::ffff:0:0/96.
+func (tree *Tree) Analyze() *AnalysisReport {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	report := &AnalysisReport{}
+	tree.analyze(report, tree.root, 0, nil, tree.v4Node())
+	return report
+}
+
+// analyze checks n against its nearest valued ancestor covering, then descends.
+func (tree *Tree) analyze(report *AnalysisReport, n *Node, depth int, covering *Node, v4 *Node) {
+	if n == nil {
+		return
+	}
+	if n == v4 {
+		covering = nil
+	}
+
+	if tree.live(n) {
+		f := Finding{Prefix: userPrefix(n.prefix), Value: n.value}
+		if covering != nil {
+			f.Covering = userPrefix(covering.prefix)
+			f.CoveringValue = covering.value
+			if defaultEqual(n.value, covering.value) {
+				report.Redundant = append(report.Redundant, f)
+			} else {
+				report.Hidden = append(report.Hidden, f)
+			}
+		}
+		if depth < 128 && tree.fullyCovered(n.left) && tree.fullyCovered(n.right) {
+			var by []*Node
+			by = tree.topLive(n.left, by)
+			by = tree.topLive(n.right, by)
+			differs := false
+			for _, b := range by {
+				f.By = append(f.By, userPrefix(b.prefix))
+				differs = differs || !defaultEqual(b.value, n.value)
+			}
+			if differs {
+				report.Shadowed = append(report.Shadowed, f)
+			}
+		}
+		covering = n
+	}
+
+	if depth < 128 {
+		tree.analyze(report, n.left, depth+1, covering, v4)
+		tree.analyze(report, n.right, depth+1, covering, v4)
+	}
+}
+
+// fullyCovered reports whether every address under n is covered by a live prefix in the subtree of n.
+func (tree *Tree) fullyCovered(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	if tree.live(n) {
+		return true
+	}
+	return tree.fullyCovered(n.left) && tree.fullyCovered(n.right)
+}
+
+// topLive appends the live nodes of the subtree of n that have no live ancestor inside that subtree.
+func (tree *Tree) topLive(n *Node, nodes []*Node) []*Node {
+	if n == nil {
+		return nodes
+	}
+	if tree.live(n) {
+		return append(nodes, n)
+	}
+	nodes = tree.topLive(n.left, nodes)
+	return tree.topLive(n.right, nodes)
+}
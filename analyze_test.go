@@ -0,0 +1,38 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestAnalyze tests detection of shadowed, redundant and hidden prefixes.
+func TestAnalyze(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/24", "deny", false)
+	tr.SetCIDRString("10.0.0.0/25", "allow", false)
+	tr.SetCIDRString("10.0.0.128/25", "deny", false)
+	tr.SetCIDRString("192.168.0.0/16", "allow", false)
+	tr.SetCIDRString("192.168.1.0/24", "allow", false)
+	tr.SetCIDRString("::/0", "allow", false)
+
+	report := tr.Analyze()
+
+	if len(report.Shadowed) != 1 || report.Shadowed[0].Prefix != netip.MustParsePrefix("10.0.0.0/24") || len(report.Shadowed[0].By) != 2 {
+		t.Errorf("Unexpected shadowed prefixes: %+v", report.Shadowed)
+	}
+
+	if len(report.Redundant) != 2 {
+		t.Fatalf("Expected 2 redundant prefixes, got %+v", report.Redundant)
+	}
+	if report.Redundant[0].Prefix != netip.MustParsePrefix("10.0.0.128/25") || report.Redundant[1].Prefix != netip.MustParsePrefix("192.168.1.0/24") {
+		t.Errorf("Unexpected redundant prefixes: %+v", report.Redundant)
+	}
+	if report.Redundant[1].Covering != netip.MustParsePrefix("192.168.0.0/16") {
+		t.Errorf("Unexpected covering prefix: %v", report.Redundant[1].Covering)
+	}
+
+	// IPv4 prefixes are not compared against ::/0
+	if len(report.Hidden) != 1 || report.Hidden[0].Prefix != netip.MustParsePrefix("10.0.0.0/25") {
+		t.Errorf("Unexpected hidden prefixes: %+v", report.Hidden)
+	}
+}
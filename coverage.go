@@ -0,0 +1,107 @@
+package nradix
+
+import (
+	"math/big"
+	"net/netip"
+	"slices"
+)
+
+// PrefixCoverage describes how much of a top-level stored prefix is used by more-specific prefixes.
+type PrefixCoverage struct {
+	Prefix      netip.Prefix
+	Size        *big.Int // addresses in Prefix
+	Covered     *big.Int // addresses in Prefix covered by more-specific stored prefixes, overlaps counted once
+	Utilization float64  // Covered divided by Size
+}
+
+// v4Node returns the node at ::ffff:0:0/96 under which IPv4 prefixes are stored, or nil.
+func (tree *Tree) v4Node() *Node {
+	key, mask := prefixKeyMask(netip.PrefixFrom(netip.IPv4Unspecified(), 0))
+	return tree.lookup6(key, mask)
+}
+
+// CoverageV4 returns the number of IPv4 addresses covered by the tree, counting overlapping prefixes once.
+func (tree *Tree) CoverageV4() *big.Int {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.coveredAddresses(tree.v4Node(), 96, nil)
+}
+
+// CoverageV6 returns the number of IPv6 /64 networks covered by IPv6 prefixes in the tree, counting
+// overlapping prefixes once. A /64 partly covered by longer prefixes counts as one.
+func (tree *Tree) CoverageV6() *big.Int {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.covered64(tree.root, 0, tree.v4Node())
+}
+
+// CoverageReport returns, for every stored prefix not covered by another stored prefix of its family,
+// how many of its addresses are covered by more-specific stored prefixes. Entries are in the tree's bit order.
+func (tree *Tree) CoverageReport() []PrefixCoverage {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	v4 := tree.v4Node()
+	var report []PrefixCoverage
+	report = tree.coverageTops(report, tree.root, 0, v4)
+	report = tree.coverageTops(report, v4, 96, nil)
+	slices.SortFunc(report, func(a, b PrefixCoverage) int { return comparePrefixes(a.Prefix, b.Prefix) })
+	return report
+}
+
+// coverageTops appends a report entry for every top-level stored prefix in the subtree of n, skipping skip.
+func (tree *Tree) coverageTops(report []PrefixCoverage, n *Node, depth int, skip *Node) []PrefixCoverage {
+	if n == nil || n == skip {
+		return report
+	}
+	if tree.live(n) {
+		size := new(big.Int).Lsh(big.NewInt(1), uint(128-depth))
+		covered := new(big.Int)
+		if depth < 128 {
+			covered.Add(tree.coveredAddresses(n.left, depth+1, skip), tree.coveredAddresses(n.right, depth+1, skip))
+		}
+		utilization, _ := new(big.Float).Quo(new(big.Float).SetInt(covered), new(big.Float).SetInt(size)).Float64()
+		return append(report, PrefixCoverage{Prefix: userPrefix(n.prefix), Size: size, Covered: covered, Utilization: utilization})
+	}
+	report = tree.coverageTops(report, n.left, depth+1, skip)
+	return tree.coverageTops(report, n.right, depth+1, skip)
+}
+
+// coveredAddresses counts the addresses covered by stored prefixes in the subtree of n, skipping skip.
+func (tree *Tree) coveredAddresses(n *Node, depth int, skip *Node) *big.Int {
+	if n == nil || n == skip {
+		return new(big.Int)
+	}
+	if tree.live(n) {
+		return new(big.Int).Lsh(big.NewInt(1), uint(128-depth))
+	}
+	if depth == 128 {
+		return new(big.Int)
+	}
+	return new(big.Int).Add(tree.coveredAddresses(n.left, depth+1, skip), tree.coveredAddresses(n.right, depth+1, skip))
+}
+
+// covered64 counts the /64 networks touched by stored prefixes in the subtree of n, skipping skip.
+func (tree *Tree) covered64(n *Node, depth int, skip *Node) *big.Int {
+	if n == nil || n == skip {
+		return new(big.Int)
+	}
+	if depth >= 64 {
+		if tree.anyLive(n, skip) {
+			return big.NewInt(1)
+		}
+		return new(big.Int)
+	}
+	if tree.live(n) {
+		return new(big.Int).Lsh(big.NewInt(1), uint(64-depth))
+	}
+	return new(big.Int).Add(tree.covered64(n.left, depth+1, skip), tree.covered64(n.right, depth+1, skip))
+}
+
+// anyLive reports whether the subtree of n holds a live value outside skip.
+func (tree *Tree) anyLive(n *Node, skip *Node) bool {
+	if n == nil || n == skip {
+		return false
+	}
+	return tree.live(n) || tree.anyLive(n.left, skip) || tree.anyLive(n.right, skip)
+}
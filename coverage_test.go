@@ -0,0 +1,38 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestCoverage tests address-space coverage counts and the per-prefix report.
+func TestCoverage(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	tr.SetCIDRString("10.1.0.0/16", 2, false)
+	tr.SetCIDRString("10.2.0.0/16", 3, false)
+	tr.SetCIDRString("192.168.1.0/24", 4, false)
+	tr.SetCIDRString("2001:db8::/48", 5, false)
+	tr.SetCIDRString("2001:db8::/56", 6, false)
+	tr.SetCIDRString("2001:db9::1/128", 7, false)
+	tr.SetCIDRString("2001:db9::2/128", 8, false)
+
+	if got := tr.CoverageV4().Int64(); got != 1<<24+256 {
+		t.Errorf("Expected %d IPv4 addresses, got %d", 1<<24+256, got)
+	}
+	if got := tr.CoverageV6().Int64(); got != 1<<16+1 {
+		t.Errorf("Expected %d IPv6 /64s, got %d", 1<<16+1, got)
+	}
+
+	report := tr.CoverageReport()
+	if len(report) != 5 {
+		t.Fatalf("Expected 5 top-level prefixes, got %+v", report)
+	}
+	top := report[0]
+	if top.Prefix != netip.MustParsePrefix("10.0.0.0/8") || top.Covered.Int64() != 2<<16 || top.Utilization != 2.0/256 {
+		t.Errorf("Unexpected coverage of 10.0.0.0/8: %+v", top)
+	}
+	if report[2].Prefix != netip.MustParsePrefix("2001:db8::/48") || report[2].Utilization != 1.0/256 {
+		t.Errorf("Unexpected coverage of 2001:db8::/48: %+v", report[2])
+	}
+}
@@ -0,0 +1,63 @@
+package nradix
+
+import (
+	"net/netip"
+)
+
+// NodeInfo describes a single node of the tree for diagnostics.
+type NodeInfo struct {
+	Node     *Node
+	Prefix   netip.Prefix // position of the node in the tree, IPv4 prefixes are left mapped
+	Stored   netip.Prefix // prefix recorded in the node, only meaningful if HasValue
+	Depth    int
+	HasLeft  bool
+	HasRight bool
+	HasValue bool
+	Expired  bool // the value has outlived its TTL but not been swept yet
+	IsRootV4 bool // the node is the ::ffff:0:0/96 shortcut used by IPv4 lookups
+}
+
+// WalkNodes calls walkFn for every node in the tree in pre-order, including the nodes without a value
+// that only join branches or are left over after deletes. It is meant for inspecting the shape of the
+// tree, not for reading its contents. SkipSubtree and SkipAll work as for Walk.
+func (tree *Tree) WalkNodes(walkFn func(NodeInfo) error) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	stack := []navPos{{n: tree.root}}
+	for len(stack) > 0 {
+		pos := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := pos.n
+
+		info := NodeInfo{
+			Node:     n,
+			Prefix:   pos.prefix(),
+			Stored:   n.prefix,
+			Depth:    pos.depth,
+			HasLeft:  n.left != nil,
+			HasRight: n.right != nil,
+			HasValue: n.value != nil,
+			Expired:  n.value != nil && tree.expired(n),
+			IsRootV4: n == tree.rootV4,
+		}
+		err := walkFn(info)
+		if err == SkipSubtree {
+			continue
+		}
+		if err != nil {
+			return skipAll(err)
+		}
+
+		if pos.depth == 128 {
+			continue
+		}
+		if n.right != nil {
+			stack = append(stack, pos.right())
+		}
+		if n.left != nil {
+			stack = append(stack, pos.left())
+		}
+	}
+	return nil
+}
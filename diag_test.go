@@ -0,0 +1,41 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestWalkNodes tests that structural nodes are reported along with valued ones.
+func TestWalkNodes(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+
+	var nodes, valued int
+	var sawRootV4 bool
+	err := tr.WalkNodes(func(info NodeInfo) error {
+		nodes++
+		if info.HasValue {
+			valued++
+			if info.Prefix != netip.MustParsePrefix("::ffff:10.0.0.0/104") || info.Depth != 104 {
+				t.Errorf("Wrong valued node: %+v", info)
+			}
+		}
+		if info.IsRootV4 {
+			sawRootV4 = true
+			if info.Depth != 96 || info.HasRight {
+				t.Errorf("Wrong rootV4 node: %+v", info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valued != 1 || !sawRootV4 {
+		t.Errorf("Expected one valued node and rootV4, got %d valued, rootV4 %v", valued, sawRootV4)
+	}
+	// the chain from the root down to /104
+	if nodes != 105 {
+		t.Errorf("Expected 105 nodes, got %d", nodes)
+	}
+}
@@ -0,0 +1,124 @@
+package nradix
+
+import (
+	"net/netip"
+	"reflect"
+	"unsafe"
+)
+
+// DiffEntry describes a prefix whose value differs between two trees.
+// Old is nil for added prefixes and New is nil for removed ones.
+type DiffEntry struct {
+	Prefix netip.Prefix
+	Old    interface{}
+	New    interface{}
+}
+
+// TreeDiff holds the prefixes added, removed and changed between two trees.
+// Each list is in the tree's bit order: by address, with a prefix before its more-specifics,
+// and IPv4 prefixes placed where ::ffff:0:0/96 sorts among IPv6 prefixes.
+type TreeDiff struct {
+	Added   []DiffEntry
+	Removed []DiffEntry
+	Changed []DiffEntry
+}
+
+// Empty reports whether the diff holds no changes.
+func (d *TreeDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// defaultEqual is used to compare values when the caller does not supply an equality function.
+func defaultEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// Diff compares two trees by walking them in lockstep and returns the prefixes that were added,
+// removed or changed going from old to new. Values are compared with equal, or reflect.DeepEqual if nil.
+// Expired TTL entries are treated as absent.
+func Diff(old, new *Tree, equal func(a, b interface{}) bool) *TreeDiff {
+	if equal == nil {
+		equal = defaultEqual
+	}
+
+	// lock in address order, so Diff(a, b) and Diff(b, a) cannot deadlock behind waiting writers
+	first, second := old, new
+	if uintptr(unsafe.Pointer(second)) < uintptr(unsafe.Pointer(first)) {
+		first, second = second, first
+	}
+	first.mutex.RLock()
+	defer first.mutex.RUnlock()
+	if second != first {
+		second.mutex.RLock()
+		defer second.mutex.RUnlock()
+	}
+
+	d := &TreeDiff{}
+	diffNodes(d, old, new, old.root, new.root, [16]byte{}, 0, equal)
+	return d
+}
+
+// diffNodes compares the nodes found at the same position in both trees and descends into their children.
+func diffNodes(d *TreeDiff, old, new *Tree, a, b *Node, addr [16]byte, depth int, equal func(a, b interface{}) bool) {
+	var av, bv interface{}
+	if a != nil && old.live(a) {
+		av = a.value
+	}
+	if b != nil && new.live(b) {
+		bv = b.value
+	}
+
+	if av != nil || bv != nil {
+		prefix := userPrefix(netip.PrefixFrom(netip.AddrFrom16(addr), depth))
+		switch {
+		case av == nil:
+			d.Added = append(d.Added, DiffEntry{Prefix: prefix, New: bv})
+		case bv == nil:
+			d.Removed = append(d.Removed, DiffEntry{Prefix: prefix, Old: av})
+		case !equal(av, bv):
+			d.Changed = append(d.Changed, DiffEntry{Prefix: prefix, Old: av, New: bv})
+		}
+	}
+
+	if depth >= 128 {
+		return
+	}
+
+	var al, ar, bl, br *Node
+	if a != nil {
+		al, ar = a.left, a.right
+	}
+	if b != nil {
+		bl, br = b.left, b.right
+	}
+	if al != nil || bl != nil {
+		diffNodes(d, old, new, al, bl, addr, depth+1, equal)
+	}
+	if ar != nil || br != nil {
+		diffNodes(d, old, new, ar, br, setBit16(addr, depth), depth+1, equal)
+	}
+}
+
+// ApplyDiff replays a change set produced by Diff onto the tree.
+// Removed prefixes are deleted, added and changed prefixes are set to their new values.
+// Removing a prefix the tree does not hold is not an error.
+func (tree *Tree) ApplyDiff(d *TreeDiff) error {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	for _, e := range d.Removed {
+		key, mask := prefixKeyMask(e.Prefix)
+		if err := tree.deleteIPv6(key, mask, false); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	for _, list := range [][]DiffEntry{d.Added, d.Changed} {
+		for _, e := range list {
+			key, mask := prefixKeyMask(e.Prefix)
+			if err := tree.insert6(key, mask, e.New, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
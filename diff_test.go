@@ -0,0 +1,90 @@
+package nradix
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDiff tests that Diff reports added, removed and changed prefixes in order and that ApplyDiff replays them.
+func TestDiff(t *testing.T) {
+	oldTree := NewTree(0)
+	oldTree.SetCIDRString("10.0.0.0/8", 1, false)
+	oldTree.SetCIDRString("10.1.0.0/16", 2, false)
+	oldTree.SetCIDRString("192.168.0.0/16", 3, false)
+	oldTree.SetCIDRString("2001:db8::/32", 4, false)
+
+	newTree := NewTree(0)
+	newTree.SetCIDRString("10.0.0.0/8", 1, false)
+	newTree.SetCIDRString("10.1.0.0/16", 20, false)
+	newTree.SetCIDRString("10.2.0.0/16", 5, false)
+	newTree.SetCIDRString("10.3.0.0/16", 6, false)
+	newTree.SetCIDRString("2001:db8::/32", 4, false)
+
+	d := Diff(oldTree, newTree, nil)
+
+	checkEntries := func(name string, got []DiffEntry, want []DiffEntry) {
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d entries, got %d: %+v", name, len(want), len(got), got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s[%d]: expected %+v, got %+v", name, i, want[i], got[i])
+			}
+		}
+	}
+	checkEntries("added", d.Added, []DiffEntry{
+		{Prefix: netip.MustParsePrefix("10.2.0.0/16"), New: 5},
+		{Prefix: netip.MustParsePrefix("10.3.0.0/16"), New: 6},
+	})
+	checkEntries("removed", d.Removed, []DiffEntry{
+		{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Old: 3},
+	})
+	checkEntries("changed", d.Changed, []DiffEntry{
+		{Prefix: netip.MustParsePrefix("10.1.0.0/16"), Old: 2, New: 20},
+	})
+
+	if err := oldTree.ApplyDiff(d); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(oldTree, newTree, nil); !d.Empty() {
+		t.Errorf("Expected no differences after ApplyDiff, got %+v", d)
+	}
+	if d := Diff(oldTree, oldTree, nil); !d.Empty() {
+		t.Errorf("Expected no differences comparing a tree with itself, got %+v", d)
+	}
+}
+
+// TestDiffConcurrent tests that diffs in both directions and writers on both trees do not deadlock.
+func TestDiffConcurrent(t *testing.T) {
+	a, b := NewTree(0), NewTree(0)
+	var wg sync.WaitGroup
+	for _, fn := range []func(i int){
+		func(int) { Diff(a, b, nil) },
+		func(int) { Diff(b, a, nil) },
+		func(i int) { a.SetCIDRString(fmt.Sprintf("10.%d.0.0/16", i%256), i, true) },
+		func(i int) { b.SetCIDRString(fmt.Sprintf("10.%d.0.0/16", i%256), i, true) },
+	} {
+		wg.Add(1)
+		go func(fn func(int)) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				fn(i)
+			}
+		}(fn)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Diff deadlocked")
+	}
+}
@@ -0,0 +1,106 @@
+package nradix
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// DOTOptions configures WriteDOT.
+type DOTOptions struct {
+	// ValuedOnly leaves out the nodes without a value, linking each stored prefix directly to the
+	// closest stored prefix above it.
+	ValuedOnly bool
+	// FormatValue renders values in node labels, fmt.Sprint is used if nil.
+	FormatValue func(interface{}) string
+}
+
+// WriteDOT writes the subtree at root as a Graphviz graph to w. An invalid root writes the whole tree.
+// Nodes are labelled with their prefix and, for stored prefixes, the formatted value; edges are
+// labelled with the bit they follow.
+func (tree *Tree) WriteDOT(w io.Writer, root netip.Prefix, opts DOTOptions) error {
+	format := opts.FormatValue
+	if format == nil {
+		format = func(v interface{}) string { return fmt.Sprint(v) }
+	}
+
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	start := navPos{n: tree.root}
+	if root.IsValid() {
+		mapped := mappedPrefix(root)
+		key, mask := prefixKeyMask(mapped)
+		start = navPos{n: tree.lookup6(key, mask), addr: mapped.Addr().As16(), depth: mapped.Bits()}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph nradix {")
+	fmt.Fprintln(bw, "\tnode [shape=ellipse];")
+	if start.n != nil {
+		type item struct {
+			pos    navPos
+			parent int    // id of the node drawn above, -1 for the start
+			bit    string // edge label
+		}
+		id := 0
+		stack := []item{{pos: start, parent: -1}}
+		for len(stack) > 0 {
+			it := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pos := it.pos
+
+			self := it.parent
+			live := tree.live(pos.n)
+			if live || !opts.ValuedOnly || it.parent < 0 {
+				self = id
+				id++
+				label := userPrefix(pos.prefix()).String()
+				if live {
+					label += "\n" + format(pos.n.value)
+					fmt.Fprintf(bw, "\tn%d [label=%q, shape=box];\n", self, label)
+				} else {
+					fmt.Fprintf(bw, "\tn%d [label=%q];\n", self, label)
+				}
+				if it.parent >= 0 {
+					fmt.Fprintf(bw, "\tn%d -> n%d [label=%q];\n", it.parent, self, it.bit)
+				}
+			}
+
+			if pos.depth == 128 {
+				continue
+			}
+			bit := func(b string) string {
+				if self != it.parent {
+					return b
+				}
+				return it.bit + b
+			}
+			if pos.n.right != nil {
+				stack = append(stack, item{pos: pos.right(), parent: self, bit: bit("1")})
+			}
+			if pos.n.left != nil {
+				stack = append(stack, item{pos: pos.left(), parent: self, bit: bit("0")})
+			}
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// Dump writes every stored prefix and its value to w, one per line in Walk order,
+// indented by how deeply the prefix is nested in other stored prefixes.
+func (tree *Tree) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	err := tree.WalkContext(context.Background(), func(prefix netip.Prefix, value interface{}, depth int) error {
+		_, err := fmt.Fprintf(bw, "%s%s %v\n", strings.Repeat("  ", depth), prefix, value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,59 @@
+package nradix
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// TestDump tests the indented listing of stored prefixes.
+func TestDump(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	tr.SetCIDRString("10.1.0.0/16", "b", false)
+	tr.SetCIDRString("2001:db8::/32", "c", false)
+
+	var buf bytes.Buffer
+	if err := tr.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "10.0.0.0/8 a\n  10.1.0.0/16 b\n2001:db8::/32 c\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
+
+// TestWriteDOT tests that the collapsed graph links stored prefixes directly.
+func TestWriteDOT(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	tr.SetCIDRString("10.1.0.0/16", "b", false)
+	tr.SetCIDRString("10.2.0.0/16", "c", false)
+
+	var buf bytes.Buffer
+	err := tr.WriteDOT(&buf, netip.MustParsePrefix("10.0.0.0/8"), DOTOptions{ValuedOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`n0 [label="10.0.0.0/8\na", shape=box];`,
+		`n1 [label="10.1.0.0/16\nb", shape=box];`,
+		`n0 -> n1 [label="00000001"];`,
+		`n0 -> n2 [label="00000010"];`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Missing %s in:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "->") != 2 {
+		t.Errorf("Expected 2 edges in:\n%s", out)
+	}
+
+	buf.Reset()
+	tr.WriteDOT(&buf, netip.MustParsePrefix("10.0.0.0/8"), DOTOptions{})
+	if strings.Count(out, "[label=") >= strings.Count(buf.String(), "[label=") {
+		t.Errorf("Expected the full graph to have more nodes and edges:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,112 @@
+package nradix
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ExplainStep is a node visited by a lookup.
+type ExplainStep struct {
+	Prefix   netip.Prefix // position of the node, IPv4 prefixes unmapped
+	HasValue bool
+	Expired  bool // the node holds a value that has outlived its TTL and is passed over
+	Value    interface{}
+}
+
+// Explanation describes how a lookup of Addr arrived at its result.
+type Explanation struct {
+	Addr netip.Addr
+	// Steps are the nodes visited, from where the lookup starts down to where the path ends.
+	// IPv4 lookups start at ::ffff:0:0/96, so they never see the IPv6 prefixes above it.
+	Steps []ExplainStep
+	// Candidates are the stored prefixes covering Addr that were passed, least specific first.
+	Candidates []Entry
+	// Ignored are stored IPv6 prefixes covering Addr that an IPv4 lookup does not consider.
+	Ignored []Entry
+	// Winner is the longest candidate, valid only if Found.
+	Winner Entry
+	Found  bool
+}
+
+// Explain looks up addr like FindCIDRNetIPAddr does and reports the path it took, the stored prefixes
+// it passed on the way and which of them won.
+func (tree *Tree) Explain(addr netip.Addr) (*Explanation, error) {
+	if !addr.IsValid() {
+		return nil, ErrBadIP
+	}
+
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	ex := &Explanation{Addr: addr}
+	key := addr.As16()
+	pos := navPos{n: tree.root}
+	if addr.Is4() && tree.rootV4 != nil {
+		// find32WithNode jumps straight to rootV4, record what it skips
+		for pos.n != nil && pos.depth < 96 {
+			if tree.live(pos.n) {
+				ex.Ignored = append(ex.Ignored, pos.entry())
+			}
+			pos = tree.step(pos, key)
+		}
+		pos = navPos{n: tree.rootV4, addr: mappedPrefix(netip.PrefixFrom(netip.IPv4Unspecified(), 0)).Addr().As16(), depth: 96}
+	}
+
+	for pos.n != nil {
+		step := ExplainStep{
+			Prefix:   userPrefix(pos.prefix()),
+			HasValue: pos.n.value != nil,
+			Expired:  pos.n.value != nil && tree.expired(pos.n),
+			Value:    pos.n.value,
+		}
+		ex.Steps = append(ex.Steps, step)
+		if tree.live(pos.n) {
+			ex.Candidates = append(ex.Candidates, pos.entry())
+		}
+		if pos.depth == 128 {
+			break
+		}
+		pos = tree.step(pos, key)
+	}
+
+	if len(ex.Candidates) > 0 {
+		ex.Winner = ex.Candidates[len(ex.Candidates)-1]
+		ex.Found = true
+	}
+	return ex, nil
+}
+
+// step moves one level down from pos towards key.
+func (tree *Tree) step(pos navPos, key [16]byte) navPos {
+	if key[pos.depth/8]&(0x80>>(pos.depth%8)) != 0 {
+		return pos.right()
+	}
+	return pos.left()
+}
+
+// String renders the explanation for people to read.
+func (ex *Explanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "lookup %s\n", ex.Addr)
+	for _, e := range ex.Ignored {
+		fmt.Fprintf(&sb, "  ignored %s = %v (IPv6 prefix, not used for IPv4 lookups)\n", e.Prefix, e.Value)
+	}
+	for _, e := range ex.Candidates {
+		fmt.Fprintf(&sb, "  matched %s = %v\n", e.Prefix, e.Value)
+	}
+	for _, s := range ex.Steps {
+		if s.Expired {
+			fmt.Fprintf(&sb, "  expired %s = %v\n", s.Prefix, s.Value)
+		}
+	}
+	if len(ex.Steps) > 0 {
+		fmt.Fprintf(&sb, "  path ends at %s after %d nodes\n", ex.Steps[len(ex.Steps)-1].Prefix, len(ex.Steps))
+	}
+	if ex.Found {
+		fmt.Fprintf(&sb, "result %s = %v\n", ex.Winner.Prefix, ex.Winner.Value)
+	} else {
+		sb.WriteString("result: no match\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestExplain tests the candidates, the winner and the IPv6 prefixes skipped by IPv4 lookups.
+func TestExplain(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("::/0", "default6", false)
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	tr.SetCIDRString("10.1.0.0/16", "b", false)
+	tr.SetCIDRString("10.1.2.0/24", "c", false)
+
+	ex, err := tr.Explain(netip.MustParseAddr("10.1.3.4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ex.Found || ex.Winner.Value != "b" {
+		t.Errorf("Expected b to win, got %+v", ex.Winner)
+	}
+	if len(ex.Candidates) != 2 || ex.Candidates[0].Prefix != netip.MustParsePrefix("10.0.0.0/8") {
+		t.Errorf("Wrong candidates: %+v", ex.Candidates)
+	}
+	if len(ex.Ignored) != 1 || ex.Ignored[0].Value != "default6" {
+		t.Errorf("Expected ::/0 to be ignored, got %+v", ex.Ignored)
+	}
+	if ex.Steps[0].Prefix != netip.MustParsePrefix("0.0.0.0/0") {
+		t.Errorf("Expected the path to start at rootV4, got %s", ex.Steps[0].Prefix)
+	}
+	if val, _ := tr.FindCIDRNetIPAddr(ex.Addr); val != ex.Winner.Value {
+		t.Errorf("Explain disagrees with the lookup: %v vs %v", ex.Winner.Value, val)
+	}
+
+	ex, _ = tr.Explain(netip.MustParseAddr("2001:db8::1"))
+	if !ex.Found || ex.Winner.Value != "default6" || ex.Steps[0].Prefix.Bits() != 0 {
+		t.Errorf("Wrong IPv6 explanation:\n%s", ex)
+	}
+}
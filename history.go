@@ -0,0 +1,156 @@
+package nradix
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// HistoryEntry is one recorded change of a prefix.
+// Value is nil for deletions; Actor and Reason are empty unless the change was made through an Auditor.
+type HistoryEntry struct {
+	Seq    uint64
+	Time   time.Time
+	Type   EventType
+	Value  interface{}
+	Actor  string
+	Reason string
+}
+
+// EnableHistory starts recording the changes of every prefix, keeping at most limit entries
+// per prefix (zero keeps everything). Changes made before the call are not recorded.
+func (tree *Tree) EnableHistory(limit int) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	if tree.history == nil {
+		tree.history = make(map[netip.Prefix][]HistoryEntry)
+	}
+	tree.historyLimit = limit
+}
+
+// History returns the recorded changes of prefix, oldest first.
+// Prefixes that have since been deleted keep their history.
+func (tree *Tree) History(prefix netip.Prefix) []HistoryEntry {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	entries := tree.history[prefix.Masked()]
+	return append([]HistoryEntry(nil), entries...)
+}
+
+// recordHistory appends a change to the history of its prefix.
+func (tree *Tree) recordHistory(ev Event) {
+	entry := HistoryEntry{Seq: ev.Seq, Time: tree.clock(), Type: ev.Type, Value: ev.New}
+	if tree.audit != nil {
+		entry.Actor = tree.audit.actor
+		entry.Reason = tree.audit.reason
+	}
+
+	entries := append(tree.history[ev.Prefix], entry)
+	if tree.historyLimit > 0 && len(entries) > tree.historyLimit {
+		entries = append(entries[:0], entries[len(entries)-tree.historyLimit:]...)
+	}
+	tree.history[ev.Prefix] = entries
+}
+
+// Auditor makes changes to a tree on behalf of an actor, attaching the actor and reason
+// to every history entry the changes produce.
+type Auditor struct {
+	tree   *Tree
+	actor  string
+	reason string
+}
+
+// Audit returns an Auditor that attributes its changes to actor, for the given reason.
+func (tree *Tree) Audit(actor, reason string) *Auditor {
+	return &Auditor{tree: tree, actor: actor, reason: reason}
+}
+
+// do runs fn with the tree locked for writing and the auditor attached.
+func (a *Auditor) do(fn func() error) error {
+	a.tree.mutex.Lock()
+	defer a.tree.mutex.Unlock()
+	a.tree.audit = a
+	defer func() { a.tree.audit = nil }()
+	return fn()
+}
+
+// SetCIDRString works like Tree.SetCIDRString.
+func (a *Auditor) SetCIDRString(cidr string, val interface{}, overwrite bool) error {
+	return a.do(func() error {
+		return a.tree.SetCIDRb([]byte(cidr), val, overwrite)
+	})
+}
+
+// SetCIDRb works like Tree.SetCIDRb.
+func (a *Auditor) SetCIDRb(cidr []byte, val interface{}, overwrite bool) error {
+	return a.do(func() error {
+		return a.tree.SetCIDRb(cidr, val, overwrite)
+	})
+}
+
+// SetCIDRNetIP works like Tree.SetCIDRNetIP.
+func (a *Auditor) SetCIDRNetIP(ip net.IP, mask net.IPMask, val interface{}, overwrite bool) error {
+	return a.do(func() error {
+		return a.tree.setCIDRNetIP(ip, mask, val, overwrite)
+	})
+}
+
+// SetCIDRNetIPAddr works like Tree.SetCIDRNetIPAddr.
+func (a *Auditor) SetCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix, val interface{}, overwrite bool) error {
+	return a.do(func() error {
+		return a.tree.setCIDRNetIPAddr(ip, mask, val, overwrite)
+	})
+}
+
+// SetCIDRNetIPPrefix works like Tree.SetCIDRNetIPPrefix.
+func (a *Auditor) SetCIDRNetIPPrefix(prefix netip.Prefix, val interface{}, overwrite bool) error {
+	if !prefix.IsValid() {
+		return ErrBadIP
+	}
+	return a.do(func() error {
+		key, mask := prefixKeyMask(prefix)
+		return a.tree.insert6(key, mask, val, overwrite)
+	})
+}
+
+// DeleteCIDRString works like Tree.DeleteCIDRString.
+func (a *Auditor) DeleteCIDRString(cidr string) error {
+	return a.do(func() error {
+		return a.tree.DeleteCIDRb([]byte(cidr))
+	})
+}
+
+// DeleteCIDRb works like Tree.DeleteCIDRb.
+func (a *Auditor) DeleteCIDRb(cidr []byte) error {
+	return a.do(func() error {
+		return a.tree.DeleteCIDRb(cidr)
+	})
+}
+
+// DeleteCIDRNetIP works like Tree.DeleteCIDRNetIP.
+func (a *Auditor) DeleteCIDRNetIP(ip net.IP, mask net.IPMask) error {
+	return a.do(func() error {
+		return a.tree.deleteCIDRNetIP(ip, mask)
+	})
+}
+
+// DeleteCIDRNetIPAddr works like Tree.DeleteCIDRNetIPAddr.
+func (a *Auditor) DeleteCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix) error {
+	return a.do(func() error {
+		return a.tree.deleteCIDRNetIPAddr(ip, mask)
+	})
+}
+
+// DeleteWholeRangeCIDR works like Tree.DeleteWholeRangeCIDR.
+func (a *Auditor) DeleteWholeRangeCIDR(cidr string) error {
+	return a.do(func() error {
+		return a.tree.DeleteWholeRangeCIDRb([]byte(cidr))
+	})
+}
+
+// DeleteWholeRangeCIDRb works like Tree.DeleteWholeRangeCIDRb.
+func (a *Auditor) DeleteWholeRangeCIDRb(cidr []byte) error {
+	return a.do(func() error {
+		return a.tree.DeleteWholeRangeCIDRb(cidr)
+	})
+}
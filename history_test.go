@@ -0,0 +1,76 @@
+package nradix
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestHistory tests that changes are recorded per prefix with their actor and reason.
+func TestHistory(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	tr.EnableHistory(0)
+
+	tr.SetCIDRString("10.0.0.0/8", "allow", false)
+	clock.Advance(time.Minute)
+	tr.Audit("alice", "ticket 42").SetCIDRString("10.0.0.0/8", "block", true)
+	clock.Advance(time.Minute)
+	tr.Audit("bob", "cleanup").DeleteWholeRangeCIDR("10.0.0.0/8")
+
+	h := tr.History(netip.MustParsePrefix("10.0.0.0/8"))
+	if len(h) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d: %+v", len(h), h)
+	}
+	if h[0].Type != EventAdd || h[0].Value != "allow" || h[0].Actor != "" {
+		t.Errorf("Unexpected first entry: %+v", h[0])
+	}
+	if h[1].Type != EventUpdate || h[1].Value != "block" || h[1].Actor != "alice" || h[1].Reason != "ticket 42" {
+		t.Errorf("Unexpected second entry: %+v", h[1])
+	}
+	if h[2].Type != EventDelete || h[2].Value != nil || h[2].Actor != "bob" || !h[2].Time.Equal(time.Unix(1700000120, 0)) {
+		t.Errorf("Unexpected third entry: %+v", h[2])
+	}
+}
+
+// TestHistoryLimit tests that only the most recent entries are kept.
+func TestHistoryLimit(t *testing.T) {
+	tr := NewTree(0)
+	tr.EnableHistory(2)
+	for i := 0; i < 5; i++ {
+		tr.SetCIDRString("2001:db8::/32", i, true)
+	}
+	h := tr.History(netip.MustParsePrefix("2001:db8::/32"))
+	if len(h) != 2 || h[0].Value != 3 || h[1].Value != 4 {
+		t.Errorf("Unexpected history: %+v", h)
+	}
+}
+
+// TestAuditorNetIP tests that changes made through the net and netip wrappers are attributed.
+func TestAuditorNetIP(t *testing.T) {
+	tr := NewTree(0)
+	tr.EnableHistory(0)
+	a := tr.Audit("carol", "migration")
+
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/8")
+	a.SetCIDRNetIP(ipnet.IP, ipnet.Mask, 1, false)
+	a.SetCIDRNetIPAddr(netip.MustParseAddr("10.0.0.0"), netip.MustParsePrefix("10.0.0.0/8"), 2, true)
+	a.SetCIDRb([]byte("10.0.0.0/8"), 3, true)
+	a.DeleteCIDRNetIP(ipnet.IP, ipnet.Mask)
+	a.SetCIDRb([]byte("10.0.0.0/8"), 4, false)
+	a.DeleteCIDRb([]byte("10.0.0.0/8"))
+	a.SetCIDRb([]byte("10.0.0.0/8"), 5, false)
+	a.DeleteWholeRangeCIDRb([]byte("10.0.0.0/8"))
+
+	h := tr.History(netip.MustParsePrefix("10.0.0.0/8"))
+	if len(h) != 8 {
+		t.Fatalf("Expected 8 history entries, got %d: %+v", len(h), h)
+	}
+	for i, e := range h {
+		if e.Actor != "carol" || e.Reason != "migration" {
+			t.Errorf("Entry %d is not attributed: %+v", i, e)
+		}
+	}
+}
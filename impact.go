@@ -0,0 +1,127 @@
+package nradix
+
+import (
+	"net/netip"
+)
+
+// ChangeOp is the kind of a proposed Change.
+type ChangeOp uint8
+
+const (
+	ChangeInsert ChangeOp = iota + 1
+	ChangeDelete
+)
+
+// Change is a proposed modification of the tree, evaluated by Impact.
+type Change struct {
+	Op     ChangeOp
+	Prefix netip.Prefix
+	Value  interface{} // value to insert, ignored for deletes
+}
+
+// ImpactEntry is a range of addresses whose lookup result would change. Old or New is nil
+// where the addresses are not matched by any prefix before or after the change.
+type ImpactEntry struct {
+	Prefix netip.Prefix
+	Old    interface{}
+	New    interface{}
+}
+
+// Impact returns the ranges whose longest-prefix-match result would change if change were applied,
+// as the smallest set of CIDRs in the tree's bit order, without modifying the tree.
+// Deleting a prefix the tree does not hold returns ErrNotFound.
+func (tree *Tree) Impact(change Change) ([]ImpactEntry, error) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	if !change.Prefix.IsValid() {
+		return nil, ErrBadIP
+	}
+	prefix := mappedPrefix(change.Prefix)
+	key, mask := prefixKeyMask(prefix)
+	node := tree.lookup6(key, mask)
+	covering := tree.coveringValue(prefix)
+
+	var old, new interface{}
+	switch change.Op {
+	case ChangeInsert:
+		if change.Value == nil {
+			return nil, ErrBadIP
+		}
+		old, new = covering, change.Value
+		if node != nil && tree.live(node) {
+			old = node.value
+		}
+	case ChangeDelete:
+		if node == nil || !tree.live(node) {
+			return nil, ErrNotFound
+		}
+		old, new = node.value, covering
+	default:
+		return nil, ErrBadIP
+	}
+
+	if old != nil && new != nil && defaultEqual(old, new) {
+		return nil, nil
+	}
+
+	// the change only affects the part of prefix not taken by more-specific prefixes
+	var impact []ImpactEntry
+	emit := func(p netip.Prefix) {
+		impact = append(impact, ImpactEntry{Prefix: userPrefix(p), Old: old, New: new})
+	}
+	addr, depth := prefix.Addr().As16(), prefix.Bits()
+	if node == nil || depth == 128 {
+		emit(prefix)
+		return impact, nil
+	}
+	// IPv4 lookups start at rootV4, so IPv6 prefixes above it never decide them
+	var skip *Node
+	if !(prefix.Addr().Is4In6() && depth >= 96) {
+		skip = tree.rootV4
+	}
+	tree.uncovered(node.left, addr, depth+1, skip, emit)
+	tree.uncovered(node.right, setBit16(addr, depth), depth+1, skip, emit)
+	return impact, nil
+}
+
+// uncovered calls emit for the largest ranges under the position (addr, depth) of n that are not covered by a live prefix,
+// leaving out the subtree at skip.
+func (tree *Tree) uncovered(n *Node, addr [16]byte, depth int, skip *Node, emit func(netip.Prefix)) {
+	if n != nil && n == skip {
+		return
+	}
+	if n == nil || (depth == 128 && !tree.live(n)) {
+		emit(netip.PrefixFrom(netip.AddrFrom16(addr), depth))
+		return
+	}
+	if tree.live(n) {
+		return
+	}
+	tree.uncovered(n.left, addr, depth+1, skip, emit)
+	tree.uncovered(n.right, setBit16(addr, depth), depth+1, skip, emit)
+}
+
+// coveringValue returns the value of the longest live prefix strictly covering the mapped prefix,
+// ignoring prefixes above ::ffff:0:0/96 for IPv4 the way find32WithNode does.
+func (tree *Tree) coveringValue(prefix netip.Prefix) interface{} {
+	minDepth := 0
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		minDepth = 96
+	}
+	key := prefix.Addr().As16()
+
+	var value interface{}
+	node := tree.root
+	for depth := 0; node != nil && depth < prefix.Bits(); depth++ {
+		if depth >= minDepth && tree.live(node) {
+			value = node.value
+		}
+		if key[depth/8]&(0x80>>(depth%8)) != 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestImpact tests the ranges reported for proposed inserts and deletes.
+func TestImpact(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	tr.SetCIDRString("10.1.0.0/16", "b", false)
+	tr.SetCIDRString("10.1.128.0/17", "c", false)
+
+	check := func(name string, got []ImpactEntry, want []ImpactEntry) {
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d entries, got %+v", name, len(want), got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s[%d]: expected %+v, got %+v", name, i, want[i], got[i])
+			}
+		}
+	}
+
+	got, err := tr.Impact(Change{Op: ChangeDelete, Prefix: netip.MustParsePrefix("10.1.0.0/16")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("delete", got, []ImpactEntry{{Prefix: netip.MustParsePrefix("10.1.0.0/17"), Old: "b", New: "a"}})
+
+	got, _ = tr.Impact(Change{Op: ChangeInsert, Prefix: netip.MustParsePrefix("10.1.0.0/16"), Value: "x"})
+	check("update", got, []ImpactEntry{{Prefix: netip.MustParsePrefix("10.1.0.0/17"), Old: "b", New: "x"}})
+
+	got, _ = tr.Impact(Change{Op: ChangeInsert, Prefix: netip.MustParsePrefix("10.1.2.0/24"), Value: "b"})
+	check("no-op insert", got, nil)
+
+	got, _ = tr.Impact(Change{Op: ChangeInsert, Prefix: netip.MustParsePrefix("192.168.0.0/16"), Value: "d"})
+	check("new insert", got, []ImpactEntry{{Prefix: netip.MustParsePrefix("192.168.0.0/16"), New: "d"}})
+
+	got, _ = tr.Impact(Change{Op: ChangeInsert, Prefix: netip.MustParsePrefix("10.0.0.0/7"), Value: "e"})
+	check("covering insert", got, []ImpactEntry{{Prefix: netip.MustParsePrefix("11.0.0.0/8"), New: "e"}})
+
+	if _, err := tr.Impact(Change{Op: ChangeDelete, Prefix: netip.MustParsePrefix("10.2.0.0/16")}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// the tree is left untouched
+	if info, _ := tr.FindCIDRString("10.1.1.1"); info != "b" {
+		t.Errorf("Expected tree to be unchanged, got %v", info)
+	}
+}
+
+// TestImpactIPv6Default tests that an IPv6 default route is not reported as changing IPv4 lookups.
+func TestImpactIPv6Default(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+
+	got, err := tr.Impact(Change{Op: ChangeInsert, Prefix: netip.MustParsePrefix("::/0"), Value: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range got {
+		if e.Prefix.Addr().Is4() || (e.Prefix.Addr().Is4In6() && e.Prefix.Bits() >= 96) {
+			t.Errorf("IPv4 range %s reported as changing", e.Prefix)
+		}
+	}
+	if len(got) == 0 {
+		t.Fatal("Expected the IPv6 space to change")
+	}
+
+	tr.SetCIDRString("::/0", "d", false)
+	if info, _ := tr.FindCIDRString("192.168.1.1"); info != nil {
+		t.Errorf("Expected IPv4 lookups to ignore ::/0, got %v", info)
+	}
+	got, _ = tr.Impact(Change{Op: ChangeDelete, Prefix: netip.MustParsePrefix("::/0")})
+	for _, e := range got {
+		if e.Prefix.Addr().Is4() {
+			t.Errorf("IPv4 range %s reported as changing on delete", e.Prefix)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package nradix
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+)
+
+// valueIndex maps keys derived from stored values to the prefixes holding those values.
+type valueIndex struct {
+	key  func(value interface{}) interface{}
+	sets map[interface{}]map[netip.Prefix]struct{}
+}
+
+// newValueIndex creates an index over the live contents of the tree.
+func (tree *Tree) newValueIndex(key func(value interface{}) interface{}) *valueIndex {
+	ix := &valueIndex{key: key, sets: make(map[interface{}]map[netip.Prefix]struct{})}
+	eachNode(tree.root, func(n *Node) {
+		if tree.live(n) {
+			ix.add(userPrefix(n.prefix), n.value)
+		}
+	})
+	return ix
+}
+
+// add indexes prefix under the key of value.
+func (ix *valueIndex) add(prefix netip.Prefix, value interface{}) {
+	k := ix.key(value)
+	if k == nil {
+		return
+	}
+	set := ix.sets[k]
+	if set == nil {
+		set = make(map[netip.Prefix]struct{})
+		ix.sets[k] = set
+	}
+	set[prefix] = struct{}{}
+}
+
+// remove drops prefix from the key of value.
+func (ix *valueIndex) remove(prefix netip.Prefix, value interface{}) {
+	k := ix.key(value)
+	if k == nil {
+		return
+	}
+	if set := ix.sets[k]; set != nil {
+		delete(set, prefix)
+		if len(set) == 0 {
+			delete(ix.sets, k)
+		}
+	}
+}
+
+// update applies a change event to the index.
+func (ix *valueIndex) update(ev Event) {
+	if ev.Old != nil {
+		ix.remove(ev.Prefix, ev.Old)
+	}
+	if ev.New != nil {
+		ix.add(ev.Prefix, ev.New)
+	}
+}
+
+// prefixes returns the prefixes indexed under k in the tree's bit order.
+func (ix *valueIndex) prefixes(k interface{}) []netip.Prefix {
+	set := ix.sets[k]
+	prefixes := make([]netip.Prefix, 0, len(set))
+	for prefix := range set {
+		prefixes = append(prefixes, prefix)
+	}
+	slices.SortFunc(prefixes, comparePrefixes)
+	return prefixes
+}
+
+// SetReverseIndex maintains an index from key(value) to the prefixes holding value, so PrefixesFor
+// does not have to walk the tree. key must return comparable keys, or nil for values that should not
+// be indexed. The index is built from the current contents; passing nil drops it.
+// Expired TTL entries stay indexed until they are swept, but PrefixesFor leaves them out.
+func (tree *Tree) SetReverseIndex(key func(value interface{}) interface{}) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	if key == nil {
+		tree.reverse = nil
+		return
+	}
+	tree.reverse = tree.newValueIndex(key)
+}
+
+// PrefixesFor returns the prefixes whose value maps to key under the reverse index, in the tree's bit order.
+// It returns nil if no reverse index has been set. Expired TTL entries are left out.
+func (tree *Tree) PrefixesFor(key interface{}) []netip.Prefix {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if tree.reverse == nil {
+		return nil
+	}
+	prefixes := tree.reverse.prefixes(key)
+	if tree.expires == nil {
+		return prefixes
+	}
+	live := prefixes[:0]
+	for _, prefix := range prefixes {
+		k, mask := prefixKeyMask(prefix)
+		if n := tree.lookup6(k, mask); n != nil && tree.live(n) {
+			live = append(live, prefix)
+		}
+	}
+	return live
+}
+
+// ErrUnknownLabel is returned by Query for labels that have not been declared with IndexLabel.
+var ErrUnknownLabel = errors.New("Label is not indexed")
+
+// LabelFilter selects stored values whose label Name equals Value.
+type LabelFilter struct {
+	Name  string
+	Value string
+}
+
+// IndexLabel declares an indexed label. fn extracts the label from a stored value and reports
+// false for values without it. The index is built from the current contents; passing a nil fn drops it.
+func (tree *Tree) IndexLabel(name string, fn func(value interface{}) (string, bool)) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	if fn == nil {
+		delete(tree.labels, name)
+		return
+	}
+	if tree.labels == nil {
+		tree.labels = make(map[string]*valueIndex)
+	}
+	tree.labels[name] = tree.newValueIndex(func(value interface{}) interface{} {
+		if label, ok := fn(value); ok {
+			return label
+		}
+		return nil
+	})
+}
+
+// Query returns the stored prefixes inside within whose values match every filter, in the tree's bit order.
+// An invalid within searches the whole tree. A query scoped to part of the tree only walks the subtree
+// under within, checking each stored prefix against the label indexes; a query over the whole tree takes
+// its candidates from the most selective label index instead. Expired TTL entries are left out either way.
+func (tree *Tree) Query(within netip.Prefix, filters ...LabelFilter) ([]netip.Prefix, error) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	sets := make([]map[netip.Prefix]struct{}, len(filters))
+	for i, f := range filters {
+		ix := tree.labels[f.Name]
+		if ix == nil {
+			return nil, ErrUnknownLabel
+		}
+		sets[i] = ix.sets[f.Value]
+	}
+	for _, set := range sets {
+		if len(set) == 0 {
+			return nil, nil
+		}
+	}
+
+	if !within.IsValid() {
+		within = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+	}
+	within = mappedPrefix(within)
+
+	// descend to the subtree the same way deleteIPv6 does; nothing stored there means nothing to match
+	key, mask := prefixKeyMask(within)
+	subtree := tree.lookup6(key, mask)
+	if subtree == nil {
+		return nil, nil
+	}
+
+	matches := func(prefix netip.Prefix) bool {
+		for _, set := range sets {
+			if _, ok := set[prefix]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	var result []netip.Prefix
+	if len(filters) == 0 || subtree != tree.root {
+		eachNode(subtree, func(n *Node) {
+			if tree.live(n) {
+				if prefix := userPrefix(n.prefix); matches(prefix) {
+					result = append(result, prefix)
+				}
+			}
+		})
+		return result, nil
+	}
+
+	smallest := 0
+	for i := range sets {
+		if len(sets[i]) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+	for prefix := range sets[smallest] {
+		if !matches(prefix) {
+			continue
+		}
+		key, mask := prefixKeyMask(prefix)
+		if n := tree.lookup6(key, mask); n != nil && tree.live(n) {
+			result = append(result, prefix)
+		}
+	}
+	slices.SortFunc(result, comparePrefixes)
+	return result, nil
+}
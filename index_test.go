@@ -0,0 +1,114 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+type customer struct {
+	ID   int
+	Name string
+}
+
+// TestReverseIndex tests that the reverse index follows inserts, updates and deletes.
+func TestReverseIndex(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", customer{42, "a"}, false)
+
+	tr.SetReverseIndex(func(value interface{}) interface{} {
+		if c, ok := value.(customer); ok {
+			return c.ID
+		}
+		return nil
+	})
+
+	tr.SetCIDRString("2001:db8::/32", customer{42, "b"}, false)
+	tr.SetCIDRString("192.168.0.0/16", customer{42, "c"}, false)
+	tr.SetCIDRString("172.16.0.0/12", customer{7, "d"}, false)
+	tr.SetCIDRString("192.168.0.0/16", customer{7, "c"}, true)
+	tr.SetCIDRString("10.1.0.0/16", "not a customer", false)
+
+	got := tr.PrefixesFor(42)
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	tr.DeleteWholeRangeCIDR("172.16.0.0/12")
+	if got := tr.PrefixesFor(7); len(got) != 1 || got[0] != netip.MustParsePrefix("192.168.0.0/16") {
+		t.Errorf("Expected only 192.168.0.0/16 for customer 7, got %v", got)
+	}
+}
+
+type labelled struct {
+	Country string
+	Tier    string
+}
+
+// TestQuery tests label queries restricted to a subtree.
+func TestQuery(t *testing.T) {
+	tr := NewTree(0)
+	tr.IndexLabel("tier", func(value interface{}) (string, bool) {
+		l, ok := value.(labelled)
+		return l.Tier, ok
+	})
+	tr.IndexLabel("country", func(value interface{}) (string, bool) {
+		l, ok := value.(labelled)
+		return l.Country, ok
+	})
+
+	tr.SetCIDRString("10.0.0.0/8", labelled{"nz", "low"}, false)
+	tr.SetCIDRString("10.2.0.0/16", labelled{"au", "high"}, false)
+	tr.SetCIDRString("10.1.0.0/16", labelled{"nz", "high"}, false)
+	tr.SetCIDRString("192.168.0.0/16", labelled{"nz", "high"}, false)
+	tr.SetCIDRString("2001:db8::/32", labelled{"nz", "high"}, false)
+
+	got, err := tr.Query(netip.MustParsePrefix("10.0.0.0/8"), LabelFilter{"tier", "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("10.2.0.0/16")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	got, _ = tr.Query(netip.Prefix{}, LabelFilter{"tier", "high"}, LabelFilter{"country", "nz"})
+	if len(got) != 3 {
+		t.Errorf("Expected 3 prefixes across the tree, got %v", got)
+	}
+
+	got, _ = tr.Query(netip.MustParsePrefix("10.0.0.0/8"))
+	if len(got) != 3 {
+		t.Errorf("Expected 3 prefixes without filters, got %v", got)
+	}
+
+	if _, err := tr.Query(netip.Prefix{}, LabelFilter{"asn", "1"}); err != ErrUnknownLabel {
+		t.Errorf("Expected ErrUnknownLabel, got %v", err)
+	}
+}
+
+// TestQueryExpired tests that expired entries are left out with and without filters.
+func TestQueryExpired(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	tr.IndexLabel("tier", func(value interface{}) (string, bool) {
+		l, ok := value.(labelled)
+		return l.Tier, ok
+	})
+	tr.SetCIDRString("10.1.0.0/16", labelled{"nz", "high"}, false)
+	tr.SetWithTTL(netip.MustParsePrefix("10.2.0.0/16"), labelled{"nz", "high"}, time.Minute)
+	clock.Advance(2 * time.Minute)
+
+	for _, within := range []netip.Prefix{{}, netip.MustParsePrefix("10.0.0.0/8")} {
+		got, _ := tr.Query(within, LabelFilter{"tier", "high"})
+		if len(got) != 1 || got[0] != netip.MustParsePrefix("10.1.0.0/16") {
+			t.Errorf("Query within %v with a filter: expected only 10.1.0.0/16, got %v", within, got)
+		}
+		got, _ = tr.Query(within)
+		if len(got) != 1 {
+			t.Errorf("Query within %v without filters: expected 1 prefix, got %v", within, got)
+		}
+	}
+}
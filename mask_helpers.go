@@ -59,3 +59,47 @@ func getNetIPPrefix(key net.IP, mask net.IPMask) netip.Prefix {
 		return netip.Prefix{}
 	}
 }
+
+// prefixKeyMask converts a prefix into the 16 byte key and mask used by insert6 and deleteIPv6.
+// IPv4 prefixes are mapped into ::ffff:0:0/96, the same way insert4 stores them.
+func prefixKeyMask(prefix netip.Prefix) (net.IP, net.IPMask) {
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	key := prefix.Addr().As16()
+	return key[:], getIPv6Mask(bits)
+}
+
+// mappedPrefix returns the canonical form of a prefix as it is laid out in the tree,
+// with IPv4 prefixes mapped into ::ffff:0:0/96.
+func mappedPrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4() {
+		return netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96).Masked()
+	}
+	return prefix.Masked()
+}
+
+// userPrefix is the inverse of mappedPrefix, it unmaps IPv4 prefixes stored under ::ffff:0:0/96.
+func userPrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked()
+	}
+	return prefix.Masked()
+}
+
+// setBit16 returns addr with the bit at the given depth set, depth 0 being the most significant bit.
+func setBit16(addr [16]byte, depth int) [16]byte {
+	addr[depth/8] |= 0x80 >> (depth % 8)
+	return addr
+}
+
+// comparePrefixes orders prefixes the way the tree lays them out: by address, with a prefix
+// before its more-specifics, and IPv4 prefixes placed at ::ffff:0:0/96.
+func comparePrefixes(a, b netip.Prefix) int {
+	ma, mb := mappedPrefix(a), mappedPrefix(b)
+	if c := ma.Addr().Compare(mb.Addr()); c != 0 {
+		return c
+	}
+	return ma.Bits() - mb.Bits()
+}
@@ -0,0 +1,364 @@
+package nradix
+
+import (
+	"math/big"
+	"net/netip"
+)
+
+// Entry is a stored prefix and its value.
+type Entry struct {
+	Prefix netip.Prefix
+	Value  interface{}
+}
+
+// navPos is a node together with its position in the tree.
+type navPos struct {
+	n     *Node
+	addr  [16]byte
+	depth int
+}
+
+// entry returns the stored prefix and value at the position.
+func (p navPos) entry() Entry {
+	return Entry{Prefix: userPrefix(p.prefix()), Value: p.n.value}
+}
+
+// prefix returns the mapped prefix of the position.
+func (p navPos) prefix() netip.Prefix {
+	return netip.PrefixFrom(netip.AddrFrom16(p.addr), p.depth)
+}
+
+// left and right return the positions of the node's children, with a nil node if the child is missing.
+func (p navPos) left() navPos {
+	return navPos{n: p.n.left, addr: p.addr, depth: p.depth + 1}
+}
+
+func (p navPos) right() navPos {
+	return navPos{n: p.n.right, addr: setBit16(p.addr, p.depth), depth: p.depth + 1}
+}
+
+// isV4 reports whether the position lies inside ::ffff:0:0/96.
+func (p navPos) isV4() bool {
+	return p.depth >= 96 && p.prefix().Addr().Is4In6()
+}
+
+// First returns the first stored prefix in the tree's bit order.
+// IPv6 prefixes below ::ffff:0:0/96 come first, then IPv4 prefixes, then the remaining IPv6 prefixes.
+func (tree *Tree) First() (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.firstIn(navPos{n: tree.root}); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Last returns the last stored prefix in the tree's bit order.
+func (tree *Tree) Last() (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.lastIn(navPos{n: tree.root}); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Next returns the first stored prefix that follows prefix in the tree's bit order.
+// prefix itself does not have to be stored.
+func (tree *Tree) Next(prefix netip.Prefix) (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.next(mappedPrefix(prefix), false, true); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Prev returns the last stored prefix that precedes prefix in the tree's bit order.
+// prefix itself does not have to be stored.
+func (tree *Tree) Prev(prefix netip.Prefix) (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.prev(mappedPrefix(prefix), false); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Floor returns the last stored prefix of the same family as addr that does not follow addr in bit order.
+// Prefixes covering addr do not follow it, so Floor returns one of them unless a stored prefix lies between.
+func (tree *Tree) Floor(addr netip.Addr) (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.floor(addr); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Ceiling returns the first stored prefix of the same family as addr that does not precede addr in bit order.
+func (tree *Tree) Ceiling(addr netip.Addr) (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	if pos, ok := tree.ceiling(addr); ok {
+		return pos.entry(), true
+	}
+	return Entry{}, false
+}
+
+// Nearest returns the longest stored prefix covering addr, or when nothing covers it, the stored prefix
+// of the same family whose range is numerically closest to addr. Ties go to the lower prefix.
+func (tree *Tree) Nearest(addr netip.Addr) (Entry, bool) {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	key := mappedPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+	if node := tree.coveringNode(key); node != nil {
+		return Entry{Prefix: userPrefix(node.prefix), Value: node.value}, true
+	}
+
+	target := new(big.Int).SetBytes(key.Addr().AsSlice())
+	var best Entry
+	var bestDist *big.Int
+
+	if pos, ok := tree.floor(addr); ok {
+		// the outermost ancestor of the floor ends closest to addr, since none of them covers it
+		minDepth := 0
+		if pos.isV4() {
+			minDepth = 96
+		}
+		n, depth := pos.n, pos.depth
+		for p, d := n.parent, depth-1; p != nil && d >= minDepth; p, d = p.parent, d-1 {
+			if tree.live(p) {
+				n, depth = p, d
+			}
+		}
+		top := netip.PrefixFrom(netip.AddrFrom16(pos.addr), depth).Masked()
+		last := new(big.Int).SetBytes(top.Addr().AsSlice())
+		last.Add(last, new(big.Int).Lsh(big.NewInt(1), uint(128-depth)))
+		last.Sub(last, big.NewInt(1))
+		best = Entry{Prefix: userPrefix(top), Value: n.value}
+		bestDist = new(big.Int).Sub(target, last)
+	}
+	if pos, ok := tree.ceiling(addr); ok {
+		first := new(big.Int).SetBytes(pos.prefix().Addr().AsSlice())
+		dist := first.Sub(first, target)
+		if bestDist == nil || dist.Cmp(bestDist) < 0 {
+			best, bestDist = pos.entry(), dist
+		}
+	}
+	return best, bestDist != nil
+}
+
+// coveringNode returns the node of the longest live prefix covering the mapped host prefix key,
+// only looking at IPv4 prefixes for IPv4 addresses like find32WithNode does.
+func (tree *Tree) coveringNode(key netip.Prefix) *Node {
+	minDepth := 0
+	if key.Addr().Is4In6() {
+		minDepth = 96
+	}
+	addr := key.Addr().As16()
+
+	var found *Node
+	node := tree.root
+	for depth := 0; node != nil; depth++ {
+		if depth >= minDepth && tree.live(node) {
+			found = node
+		}
+		if depth == 128 {
+			break
+		}
+		if addr[depth/8]&(0x80>>(depth%8)) != 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	return found
+}
+
+// floor implements Floor.
+func (tree *Tree) floor(addr netip.Addr) (navPos, bool) {
+	key := mappedPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+	pos, ok := tree.prev(key, true)
+	if ok && addr.Is6() && pos.isV4() {
+		// skip over the IPv4 block
+		pos, ok = tree.prev(mappedPrefix(netip.PrefixFrom(netip.IPv4Unspecified(), 0)), false)
+	}
+	if !ok || pos.isV4() != addr.Is4() {
+		return navPos{}, false
+	}
+	return pos, true
+}
+
+// ceiling implements Ceiling.
+func (tree *Tree) ceiling(addr netip.Addr) (navPos, bool) {
+	key := mappedPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+	pos, ok := tree.next(key, true, true)
+	if ok && addr.Is6() && pos.isV4() {
+		// skip over the IPv4 block
+		pos, ok = tree.next(mappedPrefix(netip.PrefixFrom(netip.IPv4Unspecified(), 0)), false, false)
+	}
+	if !ok || pos.isV4() != addr.Is4() {
+		return navPos{}, false
+	}
+	return pos, true
+}
+
+// path returns the positions from the root towards the mapped prefix, ending at the prefix itself
+// or at the deepest existing ancestor of it.
+func (tree *Tree) path(prefix netip.Prefix) []navPos {
+	pos := navPos{n: tree.root}
+	path := []navPos{pos}
+	key := prefix.Addr().As16()
+	for pos.depth < prefix.Bits() {
+		if key[pos.depth/8]&(0x80>>(pos.depth%8)) != 0 {
+			pos = pos.right()
+		} else {
+			pos = pos.left()
+		}
+		if pos.n == nil {
+			break
+		}
+		path = append(path, pos)
+	}
+	return path
+}
+
+// next returns the first live position after the mapped prefix in pre-order. With inclusive the prefix
+// itself qualifies, and with intoSubtree its more-specifics do; otherwise its whole subtree is skipped.
+func (tree *Tree) next(prefix netip.Prefix, inclusive, intoSubtree bool) (navPos, bool) {
+	path := tree.path(prefix)
+	key := prefix.Addr().As16()
+
+	i := len(path) - 1
+	if last := path[i]; last.depth == prefix.Bits() {
+		if inclusive && tree.live(last.n) {
+			return last, true
+		}
+		if intoSubtree && last.depth < 128 {
+			if pos, ok := tree.firstIn(last.left()); ok {
+				return pos, true
+			}
+			if pos, ok := tree.firstIn(last.right()); ok {
+				return pos, true
+			}
+		}
+		i--
+	}
+
+	// everything in the right subtree of an ancestor we left to the left comes after the prefix
+	for ; i >= 0; i-- {
+		depth := path[i].depth
+		if key[depth/8]&(0x80>>(depth%8)) == 0 {
+			if pos, ok := tree.firstIn(path[i].right()); ok {
+				return pos, true
+			}
+		}
+	}
+	return navPos{}, false
+}
+
+// prev returns the last live position before the mapped prefix in pre-order, or the prefix itself with inclusive.
+func (tree *Tree) prev(prefix netip.Prefix, inclusive bool) (navPos, bool) {
+	path := tree.path(prefix)
+	key := prefix.Addr().As16()
+
+	i := len(path) - 1
+	if last := path[i]; last.depth == prefix.Bits() {
+		if inclusive && tree.live(last.n) {
+			return last, true
+		}
+		i--
+	}
+
+	// an ancestor precedes the prefix, and so does its left subtree if we went right
+	for ; i >= 0; i-- {
+		depth := path[i].depth
+		if key[depth/8]&(0x80>>(depth%8)) != 0 {
+			if pos, ok := tree.lastIn(path[i].left()); ok {
+				return pos, true
+			}
+		}
+		if tree.live(path[i].n) {
+			return path[i], true
+		}
+	}
+	return navPos{}, false
+}
+
+// firstIn returns the first live position in pre-order in the subtree at pos.
+func (tree *Tree) firstIn(pos navPos) (navPos, bool) {
+	if pos.n == nil {
+		return navPos{}, false
+	}
+	stack := []navPos{pos}
+	for len(stack) > 0 {
+		pos = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if tree.live(pos.n) {
+			return pos, true
+		}
+		if pos.depth == 128 {
+			continue
+		}
+		if pos.n.right != nil {
+			stack = append(stack, pos.right())
+		}
+		if pos.n.left != nil {
+			stack = append(stack, pos.left())
+		}
+	}
+	return navPos{}, false
+}
+
+// lastIn returns the last live position in pre-order in the subtree at pos.
+func (tree *Tree) lastIn(pos navPos) (navPos, bool) {
+	if pos.n == nil {
+		return navPos{}, false
+	}
+	if pos.depth < 128 {
+		if found, ok := tree.lastIn(pos.right()); ok {
+			return found, true
+		}
+		if found, ok := tree.lastIn(pos.left()); ok {
+			return found, true
+		}
+	}
+	if tree.live(pos.n) {
+		return pos, true
+	}
+	return navPos{}, false
+}
+
+// Page returns up to limit stored prefixes following after in the tree's bit order, and a cursor to pass
+// as after to fetch the next page. An invalid after starts at the first prefix, and the returned cursor is
+// invalid once there is nothing left. Since the cursor is a position rather than a node, paging stays
+// correct when the tree changes between pages: prefixes inserted after the cursor are picked up,
+// and deleting the cursor's own prefix does not matter.
+func (tree *Tree) Page(after netip.Prefix, limit int) ([]Entry, netip.Prefix) {
+	if limit <= 0 {
+		return nil, after
+	}
+
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	var pos navPos
+	var ok bool
+	if after.IsValid() {
+		pos, ok = tree.next(mappedPrefix(after), false, true)
+	} else {
+		pos, ok = tree.firstIn(navPos{n: tree.root})
+	}
+
+	var entries []Entry
+	for ok && len(entries) < limit {
+		entries = append(entries, pos.entry())
+		pos, ok = tree.next(pos.prefix(), false, true)
+	}
+	if !ok {
+		return entries, netip.Prefix{}
+	}
+	return entries, entries[len(entries)-1].Prefix
+}
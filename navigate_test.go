@@ -0,0 +1,117 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestNavigateOrder tests First, Last, Next and Prev against the tree's bit order.
+func TestNavigateOrder(t *testing.T) {
+	tr := NewTree(0)
+	order := []string{"::/0", "::1/128", "10.0.0.0/8", "10.0.0.1/32", "10.0.0.2/32", "10.1.0.0/16", "10.2.0.0/16",
+		"192.168.0.0/16", "192.168.0.1/32", "2001:db8::/32", "2001:db8::1/128"}
+	for i := len(order) - 1; i >= 0; i-- {
+		tr.SetCIDRString(order[i], i, false)
+	}
+
+	if e, ok := tr.First(); !ok || e.Prefix.String() != order[0] {
+		t.Errorf("Expected first %s, got %v %v", order[0], e, ok)
+	}
+	if e, ok := tr.Last(); !ok || e.Prefix.String() != order[len(order)-1] {
+		t.Errorf("Expected last %s, got %v %v", order[len(order)-1], e, ok)
+	}
+	for i := 0; i+1 < len(order); i++ {
+		cur := netip.MustParsePrefix(order[i])
+		next := netip.MustParsePrefix(order[i+1])
+		if e, ok := tr.Next(cur); !ok || e.Prefix != next || e.Value.(int) != i+1 {
+			t.Errorf("Next(%s): expected %s, got %v %v", cur, next, e, ok)
+		}
+		if e, ok := tr.Prev(next); !ok || e.Prefix != cur {
+			t.Errorf("Prev(%s): expected %s, got %v %v", next, cur, e, ok)
+		}
+	}
+	if _, ok := tr.Next(netip.MustParsePrefix(order[len(order)-1])); ok {
+		t.Errorf("Expected nothing after the last prefix")
+	}
+	if e, ok := tr.Next(netip.MustParsePrefix("10.1.5.0/24")); !ok || e.Prefix.String() != "10.2.0.0/16" {
+		t.Errorf("Next of an absent prefix: got %v %v", e, ok)
+	}
+}
+
+// TestNavigateNearest tests Floor, Ceiling and Nearest, which stay within the address family.
+func TestNavigateNearest(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/24", "low", false)
+	tr.SetCIDRString("10.0.0.0/26", "inner", false)
+	tr.SetCIDRString("10.0.2.0/24", "high", false)
+	tr.SetCIDRString("2001:db8::/32", "v6", false)
+
+	addr := netip.MustParseAddr("10.0.1.10")
+	if e, ok := tr.Floor(addr); !ok || e.Value != "inner" {
+		t.Errorf("Floor: expected inner, got %v %v", e, ok)
+	}
+	if e, ok := tr.Ceiling(addr); !ok || e.Value != "high" {
+		t.Errorf("Ceiling: expected high, got %v %v", e, ok)
+	}
+	// 10.0.0.255 is 11 away, 10.0.2.0 is 246 away
+	if e, ok := tr.Nearest(addr); !ok || e.Value != "low" {
+		t.Errorf("Nearest: expected low, got %v %v", e, ok)
+	}
+	if e, ok := tr.Nearest(netip.MustParseAddr("10.0.1.250")); !ok || e.Value != "high" {
+		t.Errorf("Nearest: expected high, got %v %v", e, ok)
+	}
+	if e, ok := tr.Nearest(netip.MustParseAddr("10.0.0.70")); !ok || e.Value != "low" {
+		t.Errorf("Nearest of a covered address: expected low, got %v %v", e, ok)
+	}
+
+	if _, ok := tr.Ceiling(netip.MustParseAddr("10.0.3.0")); ok {
+		t.Errorf("Ceiling must not cross into IPv6")
+	}
+	if e, ok := tr.Floor(netip.MustParseAddr("2001:db9::")); !ok || e.Value != "v6" {
+		t.Errorf("Floor: expected v6, got %v %v", e, ok)
+	}
+	if e, ok := tr.Nearest(netip.MustParseAddr("::1")); !ok || e.Value != "v6" {
+		t.Errorf("Nearest must skip IPv4 prefixes, got %v %v", e, ok)
+	}
+}
+
+// TestPage tests that paging visits every prefix once, even with changes between pages.
+func TestPage(t *testing.T) {
+	tr := NewTree(0)
+	for _, cidr := range []string{"10.0.0.0/8", "10.0.0.1/32", "10.1.0.0/16", "10.2.0.0/16", "192.168.0.0/16",
+		"192.168.0.1/32", "2001:db8::/32", "2001:db8::1/128"} {
+		tr.SetCIDRString(cidr, cidr, false)
+	}
+
+	var got []string
+	entries, cursor := tr.Page(netip.Prefix{}, 3)
+	for _, e := range entries {
+		got = append(got, e.Prefix.String())
+	}
+	if cursor.String() != "10.1.0.0/16" {
+		t.Fatalf("Expected cursor 10.1.0.0/16, got %s", cursor)
+	}
+
+	tr.DeleteCIDRString("10.1.0.0/16")
+	tr.SetCIDRString("10.1.1.0/24", "10.1.1.0/24", false)
+	tr.SetCIDRString("10.0.0.0/16", "10.0.0.0/16", false)
+
+	// the later pages end on host routes, which make the cursor of the next page
+	for cursor.IsValid() {
+		entries, cursor = tr.Page(cursor, 2)
+		for _, e := range entries {
+			got = append(got, e.Prefix.String())
+		}
+	}
+
+	want := []string{"10.0.0.0/8", "10.0.0.1/32", "10.1.0.0/16", "10.1.1.0/24", "10.2.0.0/16", "192.168.0.0/16",
+		"192.168.0.1/32", "2001:db8::/32", "2001:db8::1/128"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Entry %d: expected %s, got %s", i, want[i], got[i])
+		}
+	}
+}
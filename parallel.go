@@ -0,0 +1,116 @@
+package nradix
+
+import (
+	"context"
+	"net/netip"
+	"runtime"
+	"sync"
+)
+
+// ParallelWalk calls walkFn for every stored prefix of both families like Walk, but spreads the work
+// over workers goroutines, or GOMAXPROCS when workers is not positive. The tree is split into disjoint
+// subtrees a few levels down, enough to keep the workers busy, and the whole walk runs under a single
+// read lock, so writers wait until it is done.
+//
+// walkFn is called concurrently and in no particular order, except that a prefix is always visited
+// before its more-specifics. SkipSubtree and SkipAll work as for Walk. The first other error, or the
+// cancellation of ctx, stops all workers and is returned.
+func (tree *Tree) ParallelWalk(ctx context.Context, workers int, walkFn WalkFunc) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walkState{ctx: walkCtx, fn: func(prefix netip.Prefix, value interface{}, depth int) error {
+		return walkFn(userPrefix(prefix), value)
+	}}
+
+	frontier, err := tree.splitWalk(w, 4*workers)
+	if err != nil {
+		return skipAll(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		first error
+		wg    sync.WaitGroup
+	)
+	tasks := make(chan navPos)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pos := range tasks {
+				if err := tree.walk(w, pos.n, pos.prefix(), pos.depth, 0); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, pos := range frontier {
+		select {
+		case tasks <- pos:
+		case <-walkCtx.Done():
+			break feed
+		}
+	}
+	close(tasks)
+	wg.Wait()
+
+	if first != nil && first != walkCtx.Err() {
+		return skipAll(first)
+	}
+	return ctx.Err()
+}
+
+// splitWalk expands the tree breadth-first until there are at least want subtrees to hand out or
+// nothing is left to split. Stored prefixes above the returned subtrees are visited on the way.
+func (tree *Tree) splitWalk(w *walkState, want int) ([]navPos, error) {
+	frontier := []navPos{{n: tree.root}}
+	for len(frontier) < want {
+		var next []navPos
+		split := false
+		for _, pos := range frontier {
+			if pos.depth == 128 || (pos.n.left == nil && pos.n.right == nil) {
+				next = append(next, pos)
+				continue
+			}
+			if err := w.ctx.Err(); err != nil {
+				return nil, err
+			}
+			split = true
+			if tree.live(pos.n) {
+				err := w.fn(pos.prefix(), pos.n.value, 0)
+				if err == SkipSubtree {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			if pos.n.left != nil {
+				next = append(next, pos.left())
+			}
+			if pos.n.right != nil {
+				next = append(next, pos.right())
+			}
+		}
+		frontier = next
+		if !split {
+			break
+		}
+	}
+	return frontier, nil
+}
@@ -0,0 +1,67 @@
+package nradix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestParallelWalk tests that every prefix is visited exactly once and that errors stop the walk.
+func TestParallelWalk(t *testing.T) {
+	tr := NewTree(0)
+	for i := 0; i < 256; i++ {
+		tr.SetCIDRString(fmt.Sprintf("10.%d.0.0/16", i), i, false)
+		tr.SetCIDRString(fmt.Sprintf("2001:db8:%x::/48", i), i, false)
+	}
+	tr.SetCIDRString("10.0.0.0/8", -1, false)
+
+	var mu sync.Mutex
+	seen := map[netip.Prefix]int{}
+	err := tr.ParallelWalk(context.Background(), 4, func(prefix netip.Prefix, value interface{}) error {
+		mu.Lock()
+		seen[prefix]++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 513 {
+		t.Errorf("Expected 513 prefixes, got %d", len(seen))
+	}
+	for prefix, n := range seen {
+		if n != 1 {
+			t.Errorf("%s visited %d times", prefix, n)
+		}
+	}
+
+	errStop := errors.New("stop")
+	var calls atomic.Int32
+	err = tr.ParallelWalk(context.Background(), 4, func(prefix netip.Prefix, value interface{}) error {
+		calls.Add(1)
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("Expected errStop, got %v", err)
+	}
+	if calls.Load() > 4 {
+		t.Errorf("Expected the walk to stop early, got %d calls", calls.Load())
+	}
+
+	err = tr.ParallelWalk(context.Background(), 4, func(prefix netip.Prefix, value interface{}) error {
+		return SkipAll
+	})
+	if err != nil {
+		t.Errorf("Expected SkipAll to stop cleanly, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tr.ParallelWalk(ctx, 4, func(netip.Prefix, interface{}) error { return nil }); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
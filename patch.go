@@ -0,0 +1,485 @@
+package nradix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// PatchOp identifies the kind of change carried by a PatchRecord.
+type PatchOp uint8
+
+const (
+	PatchAdd PatchOp = iota + 1
+	PatchUpdate
+	PatchDelete
+	// PatchMark carries no prefix, it tells a replica which sequence number the
+	// unsequenced records before it (a snapshot) bring it up to.
+	PatchMark
+)
+
+// PatchRecord is a single entry of a change log.
+// Records with Seq zero are unsequenced and always applied, they make up snapshots.
+// Expires is set for adds and updates of values stored with a TTL.
+type PatchRecord struct {
+	Seq     uint64
+	Op      PatchOp
+	Prefix  netip.Prefix
+	Value   interface{}
+	Expires time.Time
+}
+
+var (
+	ErrPatchCorrupt  = errors.New("Corrupt patch record")
+	ErrPatchGap      = errors.New("Patch sequence gap")
+	ErrPatchOverflow = errors.New("Patch stream fell too far behind")
+)
+
+// eventRecord returns the patch record that replays a change event.
+func eventRecord(ev Event) PatchRecord {
+	return PatchRecord{Seq: ev.Seq, Op: patchOps[ev.Type], Prefix: ev.Prefix, Value: ev.New, Expires: ev.Expires}
+}
+
+// unixNano returns t in unix nanoseconds, or zero for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// patchMagic starts every patch stream.
+var patchMagic = []byte("NRXP\x01")
+
+// ValueCodec converts stored values to and from bytes for patches and snapshots.
+type ValueCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(b []byte) (interface{}, error)
+}
+
+// GobCodec encodes values with encoding/gob. Concrete types other than the basic ones
+// must be registered with gob.Register before they can be encoded.
+var GobCodec ValueCodec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// SetValueCodec sets the codec used to encode values in patches and snapshots. Passing nil restores GobCodec.
+func (tree *Tree) SetValueCodec(codec ValueCodec) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	tree.codec = codec
+}
+
+// valueCodec returns the codec configured for the tree.
+func (tree *Tree) valueCodec() ValueCodec {
+	if tree.codec != nil {
+		return tree.codec
+	}
+	return GobCodec
+}
+
+// Sequence returns the sequence number of the last change made to the tree.
+func (tree *Tree) Sequence() uint64 {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.seq
+}
+
+// PatchWriter encodes patch records onto a stream.
+//
+// Each record is framed as a uvarint body length, the body and a CRC32 of the body.
+// The body holds the uvarint sequence number, the op, the address length and bytes,
+// the prefix length and, for adds and updates, the varint expiry in unix nanoseconds (zero for none)
+// and the uvarint length and bytes of the encoded value.
+type PatchWriter struct {
+	w     io.Writer
+	codec ValueCodec
+	buf   []byte
+}
+
+// NewPatchWriter writes the stream header to w and returns a writer for records.
+func NewPatchWriter(w io.Writer, codec ValueCodec) (*PatchWriter, error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+	if _, err := w.Write(patchMagic); err != nil {
+		return nil, err
+	}
+	return &PatchWriter{w: w, codec: codec}, nil
+}
+
+// Write encodes a single record.
+func (pw *PatchWriter) Write(rec PatchRecord) error {
+	body := binary.AppendUvarint(pw.buf[:0], rec.Seq)
+	body = append(body, byte(rec.Op))
+	if rec.Op != PatchMark {
+		addr := rec.Prefix.Addr().AsSlice()
+		body = append(body, byte(len(addr)))
+		body = append(body, addr...)
+		body = append(body, byte(rec.Prefix.Bits()))
+	}
+	if rec.Op == PatchAdd || rec.Op == PatchUpdate {
+		val, err := pw.codec.Encode(rec.Value)
+		if err != nil {
+			return err
+		}
+		body = binary.AppendVarint(body, unixNano(rec.Expires))
+		body = binary.AppendUvarint(body, uint64(len(val)))
+		body = append(body, val...)
+	}
+	pw.buf = body
+
+	frame := binary.AppendUvarint(make([]byte, 0, len(body)+binary.MaxVarintLen64+4), uint64(len(body)))
+	frame = append(frame, body...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(body))
+	_, err := pw.w.Write(frame)
+	return err
+}
+
+// PatchReader decodes patch records written by a PatchWriter.
+type PatchReader struct {
+	r      *bufio.Reader
+	codec  ValueCodec
+	offset int64
+	read   int64
+}
+
+// NewPatchReader reads and checks the stream header from r and returns a reader for records.
+func NewPatchReader(r io.Reader, codec ValueCodec) (*PatchReader, error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+	pr := &PatchReader{r: bufio.NewReader(r), codec: codec}
+	magic := make([]byte, len(patchMagic))
+	if _, err := io.ReadFull(pr.r, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, patchMagic) {
+		return nil, ErrPatchCorrupt
+	}
+	pr.offset = int64(len(patchMagic))
+	pr.read = pr.offset
+	return pr, nil
+}
+
+// Offset returns the number of stream bytes up to the end of the last complete record.
+func (pr *PatchReader) Offset() int64 {
+	return pr.offset
+}
+
+// ReadByte implements io.ByteReader so record lengths can be read as uvarints.
+func (pr *PatchReader) ReadByte() (byte, error) {
+	b, err := pr.r.ReadByte()
+	if err == nil {
+		pr.read++
+	}
+	return b, err
+}
+
+// Next returns the next record. It returns io.EOF at a clean end of stream,
+// io.ErrUnexpectedEOF if the stream ends inside a record and ErrPatchCorrupt if a record fails its checksum.
+func (pr *PatchReader) Next() (PatchRecord, error) {
+	var rec PatchRecord
+
+	// ReadUvarint only returns io.EOF when no byte of the length could be read
+	size, err := binary.ReadUvarint(pr)
+	if err != nil {
+		return rec, err
+	}
+	if size > 1<<30 {
+		return rec, ErrPatchCorrupt
+	}
+	frame := make([]byte, size+4)
+	if _, err := io.ReadFull(pr.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, err
+	}
+	pr.read += int64(len(frame))
+	body := frame[:size]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[size:]) {
+		return rec, ErrPatchCorrupt
+	}
+
+	if rec, err = pr.decode(body); err != nil {
+		return rec, err
+	}
+	pr.offset = pr.read
+	return rec, nil
+}
+
+// decode parses a record body whose checksum has been verified.
+func (pr *PatchReader) decode(body []byte) (PatchRecord, error) {
+	var rec PatchRecord
+
+	seq, n := binary.Uvarint(body)
+	if n <= 0 || len(body) < n+1 {
+		return rec, ErrPatchCorrupt
+	}
+	rec.Seq = seq
+	rec.Op = PatchOp(body[n])
+	body = body[n+1:]
+
+	if rec.Op == PatchMark {
+		return rec, nil
+	}
+	if rec.Op < PatchAdd || rec.Op > PatchDelete || len(body) < 1 {
+		return rec, ErrPatchCorrupt
+	}
+	alen := int(body[0])
+	if (alen != 4 && alen != 16) || len(body) < 1+alen+1 {
+		return rec, ErrPatchCorrupt
+	}
+	addr, _ := netip.AddrFromSlice(body[1 : 1+alen])
+	bits := int(body[1+alen])
+	if bits > addr.BitLen() {
+		return rec, ErrPatchCorrupt
+	}
+	rec.Prefix = netip.PrefixFrom(addr, bits)
+	body = body[2+alen:]
+
+	if rec.Op == PatchDelete {
+		return rec, nil
+	}
+	expires, n := binary.Varint(body)
+	if n <= 0 {
+		return rec, ErrPatchCorrupt
+	}
+	if expires != 0 {
+		rec.Expires = time.Unix(0, expires)
+	}
+	body = body[n:]
+	vlen, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) != vlen {
+		return rec, ErrPatchCorrupt
+	}
+	val, err := pr.codec.Decode(body[n:])
+	if err != nil {
+		return rec, err
+	}
+	rec.Value = val
+	return rec, nil
+}
+
+// patchOps maps change events onto patch operations.
+var patchOps = [...]PatchOp{EventAdd: PatchAdd, EventUpdate: PatchUpdate, EventDelete: PatchDelete}
+
+// patchStreamBuffer is the number of records StreamPatches holds for a writer that is behind.
+const patchStreamBuffer = 4096
+
+// StreamPatches writes a change record to w for every subsequent mutation of the tree, until stop is called.
+// Records are encoded while the tree is locked but written to w from a separate goroutine, so a slow
+// writer does not hold up the tree. If w falls more than patchStreamBuffer records behind, the stream
+// is dropped and stop returns ErrPatchOverflow; the replica has to start again from a snapshot.
+// After the first error no further records are written; stop waits for the writer and returns that error.
+func (tree *Tree) StreamPatches(w io.Writer) (stop func() error, err error) {
+	pw, err := NewPatchWriter(w, tree.valueCodec())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		werr   error
+		frames = make(chan []byte, patchStreamBuffer)
+		done   = make(chan struct{})
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if werr == nil && err != nil {
+			werr = err
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return werr != nil
+	}
+
+	go func() {
+		defer close(done)
+		for frame := range frames {
+			if failed() {
+				continue
+			}
+			if _, err := w.Write(frame); err != nil {
+				fail(err)
+			}
+		}
+	}()
+
+	// records are framed into buf, the goroutine gets a copy
+	var buf bytes.Buffer
+	enc := &PatchWriter{w: &buf, codec: pw.codec}
+	closed := false
+	drop := func(err error) {
+		fail(err)
+		closed = true
+		close(frames)
+	}
+	cancel := tree.Watch(netip.PrefixFrom(netip.IPv6Unspecified(), 0), func(ev Event) {
+		if closed {
+			return
+		}
+		if failed() {
+			drop(nil)
+			return
+		}
+		buf.Reset()
+		if err := enc.Write(eventRecord(ev)); err != nil {
+			drop(err)
+			return
+		}
+		select {
+		case frames <- append([]byte(nil), buf.Bytes()...):
+		default:
+			drop(ErrPatchOverflow)
+		}
+	})
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() {
+			cancel()
+			// the callback can no longer run, so closed is safe to read
+			if !closed {
+				close(frames)
+			}
+			<-done
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		return werr
+	}, nil
+}
+
+// WriteSnapshot writes the full contents of the tree as unsequenced add records followed by
+// a PatchMark carrying the tree's current sequence number. A replica that applies the snapshot
+// can then follow the records produced by StreamPatches.
+func (tree *Tree) WriteSnapshot(w io.Writer) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.writeSnapshot(w)
+}
+
+// writeSnapshot is WriteSnapshot without locking.
+func (tree *Tree) writeSnapshot(w io.Writer) error {
+	pw, err := NewPatchWriter(w, tree.valueCodec())
+	if err != nil {
+		return err
+	}
+
+	stack := []*Node{tree.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if tree.live(n) {
+			rec := PatchRecord{Op: PatchAdd, Prefix: userPrefix(n.prefix), Value: n.value}
+			if expires, ok := tree.expires[n]; ok {
+				rec.Expires = time.Unix(0, expires)
+			}
+			if err := pw.Write(rec); err != nil {
+				return err
+			}
+		}
+		if n.right != nil {
+			stack = append(stack, n.right)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+	}
+	return pw.Write(PatchRecord{Seq: tree.seq, Op: PatchMark})
+}
+
+// ApplyPatch reads change records from r and applies them to the tree until r is exhausted.
+// The tree is only locked while each record is applied, so it stays readable while following a stream.
+// Sequenced records already applied are skipped, and a record that skips ahead returns ErrPatchGap.
+func (tree *Tree) ApplyPatch(r io.Reader) error {
+	tree.mutex.RLock()
+	codec := tree.valueCodec()
+	tree.mutex.RUnlock()
+
+	pr, err := NewPatchReader(r, codec)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tree.mutex.Lock()
+		err = tree.applyPatchRecord(rec)
+		tree.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyPatchRecord applies a single record, the tree must be locked for writing.
+func (tree *Tree) applyPatchRecord(rec PatchRecord) error {
+	if rec.Op == PatchMark {
+		tree.applied = rec.Seq
+		tree.seq = rec.Seq
+		return nil
+	}
+
+	if rec.Seq != 0 {
+		if tree.applied != 0 && rec.Seq <= tree.applied {
+			return nil
+		}
+		if tree.applied != 0 && rec.Seq > tree.applied+1 {
+			return ErrPatchGap
+		}
+		// let the change carry the leader's sequence number, so replicas can be chained
+		tree.seq = rec.Seq - 1
+	}
+
+	key, mask := prefixKeyMask(rec.Prefix)
+	switch rec.Op {
+	case PatchAdd, PatchUpdate:
+		if err := tree.insert6Expiring(key, mask, rec.Value, true, unixNano(rec.Expires)); err != nil {
+			return err
+		}
+	case PatchDelete:
+		if err := tree.deleteIPv6(key, mask, false); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	if rec.Seq != 0 {
+		tree.seq = rec.Seq
+		tree.applied = rec.Seq
+	}
+	return nil
+}
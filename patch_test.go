@@ -0,0 +1,188 @@
+package nradix
+
+import (
+	"bytes"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestPatchReplication tests that a replica following a snapshot and a patch stream matches the leader.
+func TestPatchReplication(t *testing.T) {
+	leader := NewTree(0)
+	leader.SetCIDRString("10.0.0.0/8", 1, false)
+	leader.SetCIDRString("2001:db8::/32", "v6", false)
+
+	var snapshot bytes.Buffer
+	if err := leader.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := NewTree(0)
+	if err := replica.ApplyPatch(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- replica.ApplyPatch(pr)
+	}()
+	stop, err := leader.StreamPatches(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader.SetCIDRString("10.1.0.0/16", 2, false)
+	leader.SetCIDRString("10.0.0.0/8", 3, true)
+	leader.DeleteCIDRString("2001:db8::/32")
+	leader.SetCIDRString("192.168.0.0/16", 4, false)
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if d := Diff(leader, replica, nil); !d.Empty() {
+		t.Errorf("Replica differs from leader: %+v", d)
+	}
+	if leader.Sequence() != replica.Sequence() {
+		t.Errorf("Expected replica sequence %d, got %d", leader.Sequence(), replica.Sequence())
+	}
+}
+
+// TestPatchGap tests that a replica refuses records that skip ahead of what it has applied.
+func TestPatchGap(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPatchWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(PatchRecord{Seq: 1, Op: PatchAdd, Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: 1})
+	w.Write(PatchRecord{Seq: 1, Op: PatchAdd, Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: 1})
+	w.Write(PatchRecord{Seq: 3, Op: PatchDelete, Prefix: netip.MustParsePrefix("10.0.0.0/8")})
+
+	tr := NewTree(0)
+	if err := tr.ApplyPatch(&buf); err != ErrPatchGap {
+		t.Errorf("Expected ErrPatchGap, got %v", err)
+	}
+	info, _ := tr.FindCIDRString("10.1.1.1")
+	if info == nil || info.(int) != 1 {
+		t.Errorf("Wrong value, expected 1, got %v", info)
+	}
+}
+
+// TestPatchTornRecord tests that a truncated stream is reported as such.
+func TestPatchTornRecord(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewPatchWriter(&buf, nil)
+	w.Write(PatchRecord{Seq: 1, Op: PatchAdd, Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: 1})
+	good := buf.Len()
+	w.Write(PatchRecord{Seq: 2, Op: PatchAdd, Prefix: netip.MustParsePrefix("10.1.0.0/16"), Value: 2})
+	buf.Truncate(buf.Len() - 3)
+
+	r, err := NewPatchReader(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if r.Offset() != int64(good) {
+		t.Errorf("Expected offset %d, got %d", good, r.Offset())
+	}
+}
+
+// TestPatchTTL tests that replicas and snapshots keep the expiry of TTL entries.
+func TestPatchTTL(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	leader := NewTree(0)
+	leader.SetClock(clock.Now)
+	leader.SetWithTTL(netip.MustParsePrefix("10.0.0.0/8"), 1, time.Minute)
+
+	var stream bytes.Buffer
+	stop, err := leader.StreamPatches(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.SetWithTTL(netip.MustParsePrefix("10.1.0.0/16"), 2, time.Hour)
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := leader.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	replica := NewTree(0)
+	replica.SetClock(clock.Now)
+	if err := replica.ApplyPatch(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.ApplyPatch(&stream); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if info, _ := replica.FindCIDRString("10.2.0.1"); info != nil {
+		t.Errorf("Expected the replicated 10.0.0.0/8 to have expired, got %v", info)
+	}
+	if info, _ := replica.FindCIDRString("10.1.0.1"); info != 2 {
+		t.Errorf("Expected 2 for 10.1.0.1, got %v", info)
+	}
+	clock.Advance(time.Hour)
+	if info, _ := replica.FindCIDRString("10.1.0.1"); info != nil {
+		t.Errorf("Expected the streamed 10.1.0.0/16 to have expired, got %v", info)
+	}
+}
+
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+// TestStreamPatchesSlowWriter tests that a stalled stream neither blocks the tree nor grows without bound.
+func TestStreamPatchesSlowWriter(t *testing.T) {
+	tr := NewTree(0)
+	w := &blockingWriter{release: make(chan struct{})}
+	close(w.release) // let the header through
+	stop, err := tr.StreamPatches(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.release = make(chan struct{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < patchStreamBuffer+10; i++ {
+			tr.SetCIDRString(netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0}).String()+"/24", i, false)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Writers were blocked by the stalled stream")
+	}
+	if info, _ := tr.FindCIDRString("10.0.1.1"); info != 1 {
+		t.Errorf("Expected 1, got %v", info)
+	}
+
+	close(w.release)
+	if err := stop(); err != ErrPatchOverflow {
+		t.Errorf("Expected ErrPatchOverflow, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package nradix
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+)
+
+// PersistOptions configures a PersistentTree.
+type PersistOptions struct {
+	// CheckpointEvery writes a fresh snapshot and truncates the WAL after this many logged changes.
+	// Zero disables automatic checkpoints.
+	CheckpointEvery int
+	// Sync flushes the WAL to stable storage after every change.
+	Sync bool
+	// Codec encodes values in the WAL and snapshot, GobCodec is used if nil.
+	Codec ValueCodec
+}
+
+// PersistentTree is a Tree whose changes are appended to a write-ahead log in a directory.
+// Every SetCIDR*/DeleteCIDR* call on the embedded Tree is logged; the log is periodically folded
+// into a snapshot. Opening the directory again replays the snapshot and the log.
+//
+// Changes are logged before they are applied. A change that cannot be logged returns the error and
+// is not made, and every later change is refused with it. The error is also reported by Err,
+// Checkpoint and Close.
+type PersistentTree struct {
+	*Tree
+
+	dir     string
+	opts    PersistOptions
+	wal     *os.File
+	pw      *PatchWriter
+	pending int
+	err     error
+}
+
+// OpenPersistentTree opens or creates a persistent tree in dir.
+// A record torn by a crash at the end of the WAL is discarded.
+func OpenPersistentTree(dir string, opts PersistOptions) (*PersistentTree, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	p := &PersistentTree{Tree: NewTree(0), dir: dir, opts: opts}
+	p.codec = opts.Codec
+
+	if err := p.replaySnapshot(); err != nil {
+		return nil, err
+	}
+	if err := p.replayWAL(); err != nil {
+		return nil, err
+	}
+
+	p.journal = p.log
+	return p, nil
+}
+
+// replaySnapshot loads the last checkpoint, if there is one.
+func (p *PersistentTree) replaySnapshot() error {
+	f, err := os.Open(filepath.Join(p.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pr, err := NewPatchReader(f, p.valueCodec())
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.applyPatchRecord(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// replayWAL applies the logged changes newer than the snapshot and leaves the WAL open for appending.
+func (p *PersistentTree) replayWAL() error {
+	f, err := os.OpenFile(filepath.Join(p.dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	p.wal = f
+
+	good := int64(0)
+	pr, err := NewPatchReader(f, p.valueCodec())
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		// anything but an empty or half written header means this is not our log
+		f.Close()
+		return err
+	}
+	if err == nil {
+		for {
+			rec, err := pr.Next()
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrPatchCorrupt {
+				// a torn or corrupt record can only be the last one written before a crash
+				break
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+			if err := p.applyPatchRecord(rec); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		good = pr.Offset()
+	}
+
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if good == 0 {
+		p.pw, err = NewPatchWriter(f, p.valueCodec())
+	} else {
+		// the header is already in place
+		p.pw = &PatchWriter{w: f, codec: p.valueCodec()}
+	}
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+// log appends changes to the WAL before they are applied, it runs with the tree locked for writing.
+// Records that could not be written in full are cut off again, so the WAL only holds changes that were made.
+func (p *PersistentTree) log(evs []Event) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.opts.CheckpointEvery > 0 && p.pending >= p.opts.CheckpointEvery {
+		if err := p.checkpoint(); err != nil {
+			p.fail(err)
+			return err
+		}
+	}
+	offset, err := p.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		p.fail(err)
+		return err
+	}
+	for _, ev := range evs {
+		if err = p.pw.Write(eventRecord(ev)); err != nil {
+			break
+		}
+	}
+	if err == nil && p.opts.Sync {
+		err = p.wal.Sync()
+	}
+	if err != nil {
+		p.wal.Truncate(offset)
+		p.wal.Seek(offset, io.SeekStart)
+		p.fail(err)
+		return err
+	}
+	p.pending += len(evs)
+	return nil
+}
+
+// fail records the first logging error, later changes are refused with it.
+func (p *PersistentTree) fail(err error) {
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+// Checkpoint writes a snapshot of the tree and truncates the WAL.
+func (p *PersistentTree) Checkpoint() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.fail(p.checkpoint())
+	return p.err
+}
+
+// checkpoint is Checkpoint without locking.
+// The snapshot is written to a temporary file and renamed into place before the WAL is truncated,
+// so a crash at any point leaves either the old snapshot and full WAL or the new snapshot.
+func (p *PersistentTree) checkpoint() error {
+	tmp := filepath.Join(p.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := p.writeSnapshot(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(p.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if d, err := os.Open(p.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	if err := p.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := p.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if p.pw, err = NewPatchWriter(p.wal, p.valueCodec()); err != nil {
+		return err
+	}
+	p.pending = 0
+	return p.wal.Sync()
+}
+
+// Err returns the first error hit while logging changes.
+func (p *PersistentTree) Err() error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.err
+}
+
+// Close stops logging, flushes the WAL and closes it. The tree stays usable in memory.
+func (p *PersistentTree) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.journal = nil
+	if err := p.wal.Sync(); err != nil && p.err == nil {
+		p.err = err
+	}
+	if err := p.wal.Close(); err != nil && p.err == nil {
+		p.err = err
+	}
+	return p.err
+}
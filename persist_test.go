@@ -0,0 +1,154 @@
+package nradix
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPersistentTree tests that changes survive reopening, with and without checkpoints.
+func TestPersistentTree(t *testing.T) {
+	dir := t.TempDir()
+
+	p, err := OpenPersistentTree(dir, PersistOptions{CheckpointEvery: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetCIDRString("10.0.0.0/8", 1, false)
+	p.SetCIDRString("10.1.0.0/16", 2, false)
+	p.SetCIDRString("2001:db8::/32", "v6", false)
+	p.SetCIDRString("192.168.0.0/16", 3, false)
+	p.DeleteCIDRString("10.1.0.0/16")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Errorf("Expected a checkpoint to be written: %v", err)
+	}
+
+	reopened, err := OpenPersistentTree(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if d := Diff(p.Tree, reopened.Tree, nil); !d.Empty() {
+		t.Errorf("Reopened tree differs: %+v", d)
+	}
+	if reopened.Sequence() != p.Sequence() {
+		t.Errorf("Expected sequence %d after reopening, got %d", p.Sequence(), reopened.Sequence())
+	}
+}
+
+// TestPersistentTreeTornWAL tests that a partially written record at the end of the WAL is discarded.
+func TestPersistentTreeTornWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	p, err := OpenPersistentTree(dir, PersistOptions{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetCIDRString("10.0.0.0/8", 1, false)
+	p.SetCIDRString("10.1.0.0/16", 2, false)
+	p.Close()
+
+	wal := filepath.Join(dir, walFile)
+	info, err := os.Stat(wal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Truncate(wal, info.Size()-2)
+
+	reopened, err := OpenPersistentTree(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := reopened.FindCIDRString("10.1.2.3")
+	if value == nil || value.(int) != 1 {
+		t.Errorf("Wrong value, expected 1, got %v", value)
+	}
+
+	// the log keeps working after the torn record was dropped
+	reopened.SetCIDRString("10.2.0.0/16", 3, false)
+	reopened.Close()
+
+	again, err := OpenPersistentTree(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	value, _ = again.FindCIDRString("10.2.2.3")
+	if value == nil || value.(int) != 3 {
+		t.Errorf("Wrong value, expected 3, got %v", value)
+	}
+}
+
+// TestPersistentTreeWriteError tests that a change that cannot be logged fails, and so do later ones.
+func TestPersistentTreeWriteError(t *testing.T) {
+	p, err := OpenPersistentTree(t.TempDir(), PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetCIDRString("10.0.0.0/8", 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// make every further write to the WAL fail
+	p.wal.Close()
+
+	if err := p.SetCIDRString("10.1.0.0/16", 2, false); err == nil {
+		t.Error("Expected the unlogged change to fail")
+	}
+	if info, _ := p.FindCIDRString("10.1.0.0"); info != 1 {
+		t.Errorf("Expected the unlogged change not to be applied, got %v", info)
+	}
+	if err := p.DeleteCIDRString("10.0.0.0/8"); err == nil || err != p.Err() {
+		t.Errorf("Expected later changes to be refused with %v, got %v", p.Err(), err)
+	}
+	if info, _ := p.FindCIDRString("10.0.0.0"); info != 1 {
+		t.Errorf("Expected the refused delete to leave 10.0.0.0/8 alone, got %v", info)
+	}
+}
+
+// TestPersistentTreeTTL tests that TTL entries keep their expiry across reopening and checkpoints.
+func TestPersistentTreeTTL(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+
+	p, err := OpenPersistentTree(dir, PersistOptions{CheckpointEvery: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetClock(clock.Now)
+	p.SetWithTTL(netip.MustParsePrefix("10.0.0.0/8"), 1, time.Minute)
+	p.SetWithTTL(netip.MustParsePrefix("10.1.0.0/16"), 2, time.Hour)
+	// checkpoints before logging this one, so the first two come from the snapshot
+	p.SetWithTTL(netip.MustParsePrefix("10.2.0.0/16"), 3, time.Minute)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenPersistentTree(dir, PersistOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	reopened.SetClock(clock.Now)
+
+	clock.Advance(2 * time.Minute)
+	for _, tc := range []struct {
+		ip    string
+		value interface{}
+	}{
+		{"10.3.0.1", nil},
+		{"10.2.0.1", nil},
+		{"10.1.0.1", 2},
+	} {
+		if info, _ := reopened.FindCIDRString(tc.ip); info != tc.value {
+			t.Errorf("Expected %v for %s after reopening, got %v", tc.value, tc.ip, info)
+		}
+	}
+}
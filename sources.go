@@ -0,0 +1,172 @@
+package nradix
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// SourceValue is the value one source contributes for a prefix.
+type SourceValue struct {
+	Source string
+	Value  interface{}
+}
+
+// PrecedenceFunc picks the value stored for a prefix out of the values its sources contribute.
+// sources is never empty and is ordered by source ID. Returning nil removes the prefix from the tree.
+type PrecedenceFunc func(prefix netip.Prefix, sources []SourceValue) interface{}
+
+// PriorityPolicy returns a PrecedenceFunc that prefers sources in the given order.
+// Sources that are not listed rank after the listed ones, by source ID.
+func PriorityPolicy(order ...string) PrecedenceFunc {
+	rank := make(map[string]int, len(order))
+	for i, source := range order {
+		rank[source] = i
+	}
+	return func(prefix netip.Prefix, sources []SourceValue) interface{} {
+		best := -1
+		bestRank := len(order)
+		for i, sv := range sources {
+			r, ok := rank[sv.Source]
+			if !ok {
+				r = len(order)
+			}
+			if best == -1 || r < bestRank {
+				best, bestRank = i, r
+			}
+		}
+		return sources[best].Value
+	}
+}
+
+// SetSourcePolicy sets how the values of several sources for the same prefix are resolved
+// and re-resolves every sourced prefix. Passing nil restores the default, PriorityPolicy().
+func (tree *Tree) SetSourcePolicy(policy PrecedenceFunc) error {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	tree.policy = policy
+	for prefix := range tree.sources {
+		if err := tree.resolveSources(prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSourced records the value source contributes for prefix and stores the value resolved
+// from all of the prefix's sources in the tree.
+func (tree *Tree) SetSourced(prefix netip.Prefix, source string, val interface{}) error {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	if !prefix.IsValid() {
+		return ErrBadIP
+	}
+	prefix = prefix.Masked()
+
+	if tree.sources == nil {
+		tree.sources = make(map[netip.Prefix][]SourceValue)
+		tree.bySource = make(map[string]map[netip.Prefix]struct{})
+	}
+
+	list := tree.sources[prefix]
+	i := sort.Search(len(list), func(i int) bool { return list[i].Source >= source })
+	if i < len(list) && list[i].Source == source {
+		list[i].Value = val
+	} else {
+		list = append(list, SourceValue{})
+		copy(list[i+1:], list[i:])
+		list[i] = SourceValue{Source: source, Value: val}
+	}
+	tree.sources[prefix] = list
+
+	if tree.bySource[source] == nil {
+		tree.bySource[source] = make(map[netip.Prefix]struct{})
+	}
+	tree.bySource[source][prefix] = struct{}{}
+
+	return tree.resolveSources(prefix)
+}
+
+// DeleteSourced withdraws the value source contributes for prefix.
+// The prefix stays in the tree as long as another source still contributes it.
+func (tree *Tree) DeleteSourced(prefix netip.Prefix, source string) error {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	prefix = prefix.Masked()
+	if _, ok := tree.bySource[source][prefix]; !ok {
+		return ErrNotFound
+	}
+	tree.dropSource(prefix, source)
+	return tree.resolveSources(prefix)
+}
+
+// DeleteSource withdraws every value contributed by source and returns the number of prefixes affected.
+// Prefixes still contributed by other sources are re-resolved, the others are removed from the tree.
+func (tree *Tree) DeleteSource(source string) (int, error) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	prefixes := tree.bySource[source]
+	affected := len(prefixes)
+	for prefix := range prefixes {
+		tree.dropSource(prefix, source)
+		if err := tree.resolveSources(prefix); err != nil {
+			return 0, err
+		}
+	}
+	return affected, nil
+}
+
+// Sources returns the values each source contributes for prefix, ordered by source ID.
+func (tree *Tree) Sources(prefix netip.Prefix) []SourceValue {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return append([]SourceValue(nil), tree.sources[prefix.Masked()]...)
+}
+
+// dropSource removes source from the bookkeeping of prefix.
+func (tree *Tree) dropSource(prefix netip.Prefix, source string) {
+	list := tree.sources[prefix]
+	for i := range list {
+		if list[i].Source == source {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(tree.sources, prefix)
+	} else {
+		tree.sources[prefix] = list
+	}
+
+	delete(tree.bySource[source], prefix)
+	if len(tree.bySource[source]) == 0 {
+		delete(tree.bySource, source)
+	}
+}
+
+// resolveSources stores the value resolved from the sources of prefix, or removes the prefix
+// when no source contributes it any more. Unchanged values are left alone.
+func (tree *Tree) resolveSources(prefix netip.Prefix) error {
+	var val interface{}
+	if list := tree.sources[prefix]; len(list) > 0 {
+		policy := tree.policy
+		if policy == nil {
+			policy = PriorityPolicy()
+		}
+		val = policy(prefix, list)
+	}
+
+	key, mask := prefixKeyMask(prefix)
+	if val == nil {
+		if err := tree.deleteIPv6(key, mask, false); err != nil && err != ErrNotFound {
+			return err
+		}
+		return nil
+	}
+	if node := tree.lookup6(key, mask); node != nil && tree.live(node) && defaultEqual(node.value, val) {
+		return nil
+	}
+	return tree.insert6(key, mask, val, true)
+}
@@ -0,0 +1,51 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestSources tests precedence between sources and removing a whole source.
+func TestSources(t *testing.T) {
+	tr := NewTree(0)
+	if err := tr.SetSourcePolicy(PriorityPolicy("manual", "feed-a")); err != nil {
+		t.Fatal(err)
+	}
+
+	shared := netip.MustParsePrefix("10.0.0.0/8")
+	only := netip.MustParsePrefix("192.168.0.0/16")
+	tr.SetSourced(shared, "feed-b", "b")
+	tr.SetSourced(shared, "feed-a", "a")
+	tr.SetSourced(only, "feed-a", "a")
+
+	info, _ := tr.FindCIDRString("10.1.1.1")
+	if info != "a" {
+		t.Errorf("Expected feed-a to win, got %v", info)
+	}
+
+	tr.SetSourced(shared, "manual", "m")
+	info, _ = tr.FindCIDRString("10.1.1.1")
+	if info != "m" {
+		t.Errorf("Expected manual to win, got %v", info)
+	}
+
+	if n, err := tr.DeleteSource("feed-a"); err != nil || n != 2 {
+		t.Errorf("Expected feed-a to affect 2 prefixes, got %d (%v)", n, err)
+	}
+	info, _ = tr.FindCIDRString("192.168.1.1")
+	if info != nil {
+		t.Errorf("Expected prefix only contributed by feed-a to be removed, got %v", info)
+	}
+
+	tr.DeleteSourced(shared, "manual")
+	info, _ = tr.FindCIDRString("10.1.1.1")
+	if info != "b" {
+		t.Errorf("Expected feed-b to remain, got %v", info)
+	}
+	if sv := tr.Sources(shared); len(sv) != 1 || sv[0].Source != "feed-b" {
+		t.Errorf("Unexpected sources: %+v", sv)
+	}
+	if err := tr.DeleteSourced(shared, "manual"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package nradix
+
+import (
+	"unsafe"
+)
+
+// Stats describes the size of a tree.
+type Stats struct {
+	PrefixesV4 int      // stored IPv4 prefixes
+	PrefixesV6 int      // stored IPv6 prefixes
+	LengthsV4  [33]int  // stored IPv4 prefixes by prefix length
+	LengthsV6  [129]int // stored IPv6 prefixes by prefix length
+	Nodes      int      // nodes in the tree, with or without a value
+	FreeNodes  int      // nodes on the free list waiting to be reused
+	ArenaSlots int      // node slots allocated in tree.alloc rows
+	ArenaUsed  int      // slots handed out from those rows, the sum of Nodes and FreeNodes
+	MaxDepth   int      // depth of the deepest stored prefix, IPv4 prefixes are 96 bits deeper
+	Bytes      int64    // estimated memory held by the node slots, not counting the values
+}
+
+// Stats returns size figures for the tree. They are kept up to date as the tree changes,
+// so Stats is cheap enough to call for every metrics scrape. Expired values that have not
+// been swept yet are still counted.
+func (tree *Tree) Stats() Stats {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	st := Stats{
+		LengthsV4:  tree.countsV4,
+		LengthsV6:  tree.countsV6,
+		Nodes:      tree.arenaUsed - tree.freeNodes,
+		FreeNodes:  tree.freeNodes,
+		ArenaSlots: tree.arenaSlots,
+		ArenaUsed:  tree.arenaUsed,
+		Bytes:      int64(tree.arenaSlots) * int64(unsafe.Sizeof(Node{})),
+	}
+	for bits, n := range st.LengthsV6 {
+		st.PrefixesV6 += n
+		if n > 0 {
+			st.MaxDepth = bits
+		}
+	}
+	for bits, n := range st.LengthsV4 {
+		st.PrefixesV4 += n
+		if n > 0 && bits+96 > st.MaxDepth {
+			st.MaxDepth = bits + 96
+		}
+	}
+	return st
+}
+
+// countPrefix keeps the per-length counts in step with a change.
+func (tree *Tree) countPrefix(ev Event) {
+	delta := 0
+	switch ev.Type {
+	case EventAdd:
+		delta = 1
+	case EventDelete:
+		delta = -1
+	default:
+		return
+	}
+	if ev.Prefix.Addr().Is4() {
+		tree.countsV4[ev.Prefix.Bits()] += delta
+	} else {
+		tree.countsV6[ev.Prefix.Bits()] += delta
+	}
+}
@@ -0,0 +1,56 @@
+package nradix
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestStats tests the prefix and node counts through inserts and deletes.
+func TestStats(t *testing.T) {
+	tr := NewTree(0)
+	empty := tr.Stats()
+	if empty.PrefixesV4+empty.PrefixesV6 != 0 || empty.Nodes != 97 || empty.MaxDepth != 0 {
+		t.Errorf("Wrong stats for an empty tree: %+v", empty)
+	}
+
+	for i := 0; i < 16; i++ {
+		tr.SetCIDRString(fmt.Sprintf("10.%d.0.0/16", i), i, false)
+	}
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	tr.SetCIDRString("10.0.0.0/8", "b", true)
+	tr.SetCIDRString("2001:db8::/32", "c", false)
+	tr.SetCIDRString("2001:db8::1/128", "d", false)
+
+	st := tr.Stats()
+	if st.PrefixesV4 != 17 || st.LengthsV4[16] != 16 || st.LengthsV4[8] != 1 {
+		t.Errorf("Wrong IPv4 counts: %+v", st)
+	}
+	if st.PrefixesV6 != 2 || st.LengthsV6[128] != 1 || st.MaxDepth != 128 {
+		t.Errorf("Wrong IPv6 counts: %+v", st)
+	}
+	if st.Nodes+st.FreeNodes != st.ArenaUsed || st.ArenaUsed > st.ArenaSlots || st.Bytes <= 0 {
+		t.Errorf("Inconsistent node counts: %+v", st)
+	}
+
+	// the more-specifics of a deleted range are recycled
+	tr.DeleteWholeRangeCIDR("10.0.0.0/8")
+	st = tr.Stats()
+	if st.PrefixesV4 != 0 || st.FreeNodes == 0 || st.Nodes+st.FreeNodes != st.ArenaUsed {
+		t.Errorf("Wrong counts after deleting a range: %+v", st)
+	}
+	used := st.ArenaUsed
+	tr.SetCIDRString("10.0.0.0/8", "a", false)
+	if st = tr.Stats(); st.ArenaUsed != used {
+		t.Errorf("Expected free nodes to be reused, arena grew from %d to %d", used, st.ArenaUsed)
+	}
+
+	// deleting everything keeps the root and the IPv4 shortcut
+	tr.DeleteWholeRangeCIDR("::/0")
+	st = tr.Stats()
+	if st.PrefixesV4+st.PrefixesV6 != 0 || st.Nodes != empty.Nodes {
+		t.Errorf("Wrong counts after deleting everything: %+v", st)
+	}
+	if err := tr.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,12 +7,14 @@ package nradix
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"sync"
+	"time"
 )
 
 // Tree implements a radix tree for working with IP/mask.
@@ -25,6 +27,36 @@ type Tree struct {
 
 	alloc []Node
 	mutex sync.RWMutex
+
+	now     func() time.Time // clock used for TTL expiry, nil means time.Now
+	expires map[*Node]int64  // unix nanoseconds after which a node's value is treated as absent, nil until SetWithTTL is used
+
+	watchers []*watcher
+	seq      uint64 // number of the last change made to the tree
+	applied  uint64 // sequence number of the last patch record applied, see ApplyPatch
+	codec    ValueCodec
+
+	history      map[netip.Prefix][]HistoryEntry // nil unless EnableHistory was called
+	historyLimit int
+	audit        *Auditor // attribution for the change in progress, see Audit
+
+	sources  map[netip.Prefix][]SourceValue // per-source values of prefixes set with SetSourced
+	bySource map[string]map[netip.Prefix]struct{}
+	policy   PrecedenceFunc
+
+	reverse *valueIndex            // see SetReverseIndex
+	labels  map[string]*valueIndex // see IndexLabel
+
+	monoid Monoid                // see SetAggregator
+	aggs   map[*Node]interface{} // aggregate of each node's subtree under monoid, nil without one
+
+	journal func(evs []Event) error // sees every change before it is applied and can refuse it, see PersistentTree
+
+	countsV4   [33]int  // stored IPv4 prefixes by length, see Stats
+	countsV6   [129]int // stored IPv6 prefixes by length
+	arenaSlots int      // node slots reserved in alloc rows so far
+	arenaUsed  int      // slots handed out from those rows
+	freeNodes  int      // length of the free list
 }
 
 const (
@@ -135,7 +167,11 @@ func (tree *Tree) SetCIDRString(cidr string, val interface{}, overwrite bool) er
 func (tree *Tree) SetCIDRNetIP(ip net.IP, mask net.IPMask, val interface{}, overwrite bool) error {
 	tree.mutex.Lock()
 	defer tree.mutex.Unlock()
+	return tree.setCIDRNetIP(ip, mask, val, overwrite)
+}
 
+// setCIDRNetIP is SetCIDRNetIP without locking.
+func (tree *Tree) setCIDRNetIP(ip net.IP, mask net.IPMask, val interface{}, overwrite bool) error {
 	// Optimize IPv4 check using To4() which handles all IPv4 cases including mapped addresses
 	if ip4 := ip.To4(); ip4 != nil {
 		ipFlat := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
@@ -149,7 +185,11 @@ func (tree *Tree) SetCIDRNetIP(ip net.IP, mask net.IPMask, val interface{}, over
 func (tree *Tree) SetCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix, val interface{}, overwrite bool) error {
 	tree.mutex.Lock()
 	defer tree.mutex.Unlock()
+	return tree.setCIDRNetIPAddr(ip, mask, val, overwrite)
+}
 
+// setCIDRNetIPAddr is SetCIDRNetIPAddr without locking.
+func (tree *Tree) setCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix, val interface{}, overwrite bool) error {
 	if ip.Is4() {
 		ipv4 := ip.As4()
 		ipFlat := uint32(ipv4[0])<<24 | uint32(ipv4[1])<<16 | uint32(ipv4[2])<<8 | uint32(ipv4[3])
@@ -244,6 +284,11 @@ func (tree *Tree) DeleteCIDRString(cidr string) error {
 func (tree *Tree) DeleteCIDRNetIP(ip net.IP, mask net.IPMask) error {
 	tree.mutex.Lock()
 	defer tree.mutex.Unlock()
+	return tree.deleteCIDRNetIP(ip, mask)
+}
+
+// deleteCIDRNetIP is DeleteCIDRNetIP without locking.
+func (tree *Tree) deleteCIDRNetIP(ip net.IP, mask net.IPMask) error {
 	if len(ip) == 4 {
 		return tree.deleteIPv4(uint32(ip[0])<<24|uint32(ip[1])<<16|uint32(ip[2])<<8|uint32(ip[3]), uint32(mask[0])<<24|uint32(mask[1])<<16|uint32(mask[2])<<8|uint32(mask[3]), false)
 	}
@@ -256,7 +301,11 @@ func (tree *Tree) DeleteCIDRNetIP(ip net.IP, mask net.IPMask) error {
 func (tree *Tree) DeleteCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix) error {
 	tree.mutex.Lock()
 	defer tree.mutex.Unlock()
+	return tree.deleteCIDRNetIPAddr(ip, mask)
+}
 
+// deleteCIDRNetIPAddr is DeleteCIDRNetIPAddr without locking.
+func (tree *Tree) deleteCIDRNetIPAddr(ip netip.Addr, mask netip.Prefix) error {
 	if ip.Is4() {
 		ipv4 := ip.As4()
 		ipFlat := uint32(ipv4[0])<<24 | uint32(ipv4[1])<<16 | uint32(ipv4[2])<<8 | uint32(ipv4[3])
@@ -426,6 +475,11 @@ func (tree *Tree) insert4(key, mask uint32, value interface{}, overwrite bool) e
 // insert6 inserts a value into the tree for a given IPv6 key and mask.
 // It traverses the tree based on the key and mask, creating new nodes as necessary, and sets the value at the appropriate node.
 func (tree *Tree) insert6(key net.IP, mask net.IPMask, value interface{}, overwrite bool) error {
+	return tree.insert6Expiring(key, mask, value, overwrite, 0)
+}
+
+// insert6Expiring is insert6 for a value that expires at the given time in unix nanoseconds, zero for never.
+func (tree *Tree) insert6Expiring(key net.IP, mask net.IPMask, value interface{}, overwrite bool, expires int64) error {
 	if len(key) != len(mask) {
 		return ErrBadIP
 	}
@@ -454,14 +508,31 @@ func (tree *Tree) insert6(key net.IP, mask net.IPMask, value interface{}, overwr
 
 	}
 	if next != nil {
-		if node.value != nil && !overwrite {
+		if node.value != nil && !overwrite && !tree.expired(node) {
 			return ErrNodeBusy
 		}
+		old := node.value
+		if old != nil && tree.expired(node) {
+			// an expired value already counts as absent, so this is an add
+			old = nil
+		}
+		if err := tree.logAhead(change{prefix: getNetIPPrefix(key, mask), old: old, new: value, expires: expires}); err != nil {
+			return err
+		}
+		if old == nil && node.value != nil {
+			tree.dropExpired(node.prefix, node.value)
+		}
 		node.value = value
+		tree.setExpiry(node, expires)
 		node.prefix = getNetIPPrefix(key, mask)
+		tree.reaggregate(node)
+		tree.notify(node.prefix, old, value, expires)
 		return nil
 	}
 
+	if err := tree.logAhead(change{prefix: getNetIPPrefix(key, mask), new: value, expires: expires}); err != nil {
+		return err
+	}
 	for bit&mask[i] != 0 {
 		next = tree.newnode(key, mask)
 		next.parent = node
@@ -479,7 +550,10 @@ func (tree *Tree) insert6(key net.IP, mask net.IPMask, value interface{}, overwr
 		}
 	}
 	node.value = value
+	tree.setExpiry(node, expires)
 	node.prefix = getNetIPPrefix(key, mask)
+	tree.reaggregate(node)
+	tree.notify(node.prefix, nil, value, expires)
 
 	return nil
 }
@@ -539,12 +613,52 @@ func (tree *Tree) deleteIPv6(key net.IP, mask net.IPMask, wholeRange bool) error
 	if !wholeRange && (node.right != nil || node.left != nil) {
 		// keep it just trim value
 		if node.value != nil {
+			old := node.value
+			if err := tree.logAhead(change{prefix: node.prefix, old: old}); err != nil {
+				return err
+			}
 			node.value = nil
+			delete(tree.expires, node)
+			tree.reaggregate(node)
+			tree.notify(node.prefix, old, nil, 0)
 			return nil
 		}
 		return ErrNotFound
 	}
 
+	// remember the values being removed, they are announced once the tree is consistent again
+	var removed []change
+	if wholeRange {
+		removed = collectValues(node, removed)
+	} else if node.value != nil {
+		removed = append(removed, change{prefix: node.prefix, old: node.value})
+	}
+	if err := tree.logAhead(removed...); err != nil {
+		return err
+	}
+	defer func() {
+		for _, c := range removed {
+			tree.notify(c.prefix, c.old, nil, 0)
+		}
+	}()
+
+	if wholeRange && tree.freeSubtree(node) {
+		// the root and the IPv4 shortcut chain stay attached, emptied
+		if tree.rootV4 != nil {
+			tree.reaggregate(tree.rootV4)
+		} else {
+			tree.reaggregate(node)
+		}
+		return nil
+	}
+
+	if node == tree.rootV4 {
+		// the IPv4 shortcut must stay attached, only drop what hangs off it
+		node.left, node.right, node.value = nil, nil, nil
+		tree.reaggregate(node)
+		return nil
+	}
+
 	// need to trim leaf
 	for {
 		if node.parent.right == node {
@@ -553,19 +667,19 @@ func (tree *Tree) deleteIPv6(key net.IP, mask net.IPMask, wholeRange bool) error
 			node.parent.left = nil
 		}
 		// reserve this node for future use
-		node.right = tree.free
-		tree.free = node
+		tree.freeNode(node)
 
 		// move to parent, check if it's free of value and children
 		node = node.parent
 		if node.right != nil || node.left != nil || node.value != nil {
 			break
 		}
-		// do not delete root node
-		if node.parent == nil {
+		// do not delete root node or the IPv4 shortcut chain
+		if node.parent == nil || node == tree.rootV4 {
 			break
 		}
 	}
+	tree.reaggregate(node)
 
 	return nil
 }
@@ -584,7 +698,9 @@ func (tree *Tree) find32WithNode(ipv4 uint32, mask uint32) (nodeRet *Node, value
 	if tree.rootV4 != nil {
 		node := tree.rootV4
 		bit := startbit
-		value = node.value // Store initial value if exists
+		if tree.live(node) {
+			value = node.value // Store initial value if exists
+		}
 		nodeRet = node
 
 		for node != nil && bit != 0 {
@@ -598,7 +714,7 @@ func (tree *Tree) find32WithNode(ipv4 uint32, mask uint32) (nodeRet *Node, value
 				node = node.left
 			}
 
-			if node != nil && node.value != nil {
+			if node != nil && tree.live(node) {
 				value = node.value
 				nodeRet = node
 			}
@@ -648,7 +764,7 @@ func (tree *Tree) find6WithNode(key net.IP, mask net.IPMask) (nodeRet *Node, val
 	var i int
 	bit := startbyte
 	for node != nil {
-		if node.value != nil {
+		if tree.live(node) {
 			value = node.value
 			nodeRet = node
 		}
@@ -664,7 +780,7 @@ func (tree *Tree) find6WithNode(key net.IP, mask net.IPMask) (nodeRet *Node, val
 			i, bit = i+1, startbyte
 			if i >= len(key) {
 				// reached depth of the tree, there should be matching node...
-				if node != nil {
+				if node != nil && tree.live(node) {
 					value = node.value
 					nodeRet = node
 				}
@@ -675,12 +791,97 @@ func (tree *Tree) find6WithNode(key net.IP, mask net.IPMask) (nodeRet *Node, val
 	return nodeRet, value
 }
 
+// lookup6 returns the node stored exactly at the given IPv6 key and mask, or nil if there is none.
+// Unlike find6 it does not fall back to covering prefixes.
+func (tree *Tree) lookup6(key net.IP, mask net.IPMask) *Node {
+	if len(key) != len(mask) {
+		return nil
+	}
+	var i int
+	bit := startbyte
+	node := tree.root
+	for node != nil && bit&mask[i] != 0 {
+		if key[i]&bit != 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+		if bit >>= 1; bit == 0 {
+			if i++; i == len(key) {
+				break
+			}
+			bit = startbyte
+		}
+	}
+	return node
+}
+
+// eachNode calls fn for n and every node below it, in pre-order.
+func eachNode(n *Node, fn func(n *Node)) {
+	stack := []*Node{n}
+	for len(stack) > 0 {
+		n = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		fn(n)
+		if n.right != nil {
+			stack = append(stack, n.right)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+	}
+}
+
+// freeNode puts a node that has been unlinked from the tree on the free list.
+func (tree *Tree) freeNode(n *Node) {
+	n.right = tree.free
+	tree.free = n
+	tree.freeNodes++
+	delete(tree.expires, n)
+	delete(tree.aggs, n)
+}
+
+// freeSubtree drops the values below and at n and puts the nodes below n on the free list.
+// The root and the chain of nodes leading to rootV4 stay in place with only the chain linked,
+// and it reports whether n is one of those, in which case it must not be trimmed itself.
+func (tree *Tree) freeSubtree(n *Node) (keep bool) {
+	chain := make(map[*Node]bool)
+	for c := tree.rootV4; c != nil; c = c.parent {
+		chain[c] = true
+	}
+
+	var nodes []*Node
+	eachNode(n, func(c *Node) { nodes = append(nodes, c) })
+	for _, c := range nodes {
+		switch {
+		case chain[c]:
+			// rootV4 keeps no children, the rest of the chain only the link towards it
+			c.value = nil
+			if c.left != nil && !chain[c.left] {
+				c.left = nil
+			}
+			if c.right != nil && !chain[c.right] {
+				c.right = nil
+			}
+		case c != n:
+			tree.freeNode(c)
+		}
+	}
+	if chain[n] || n == tree.root {
+		n.value = nil
+		return true
+	}
+	n.left, n.right = nil, nil
+	return false
+}
+
 // newnode creates a new node for the tree, reusing a node from the free list if available.
 // It initializes the node's fields and returns a pointer to the new node.
 func (tree *Tree) newnode(key net.IP, mask net.IPMask) (p *Node) {
 	if tree.free != nil {
 		p = tree.free
 		tree.free = tree.free.right
+		tree.freeNodes--
 
 		// release all prior links
 		p.right = nil
@@ -695,10 +896,12 @@ func (tree *Tree) newnode(key net.IP, mask net.IPMask) (p *Node) {
 	if ln == cap(tree.alloc) {
 		// filled one row, make bigger one
 		tree.alloc = make([]Node, ln+200)[:1] // 200, 600, 1400, 3000, 6200, 12600 ...
+		tree.arenaSlots += ln + 200
 		ln = 0
 	} else {
 		tree.alloc = tree.alloc[:ln+1]
 	}
+	tree.arenaUsed++
 	return &(tree.alloc[ln])
 }
 
@@ -781,76 +984,232 @@ func parsecidr6(cidr []byte) (net.IP, net.IPMask, error) {
 	return ip, net.IPMask{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil
 }
 
+var (
+	// SkipSubtree can be returned by a walk function to skip the more-specific prefixes of the current one.
+	SkipSubtree = errors.New("Skip this subtree")
+	// SkipAll can be returned by a walk function to stop the walk without an error.
+	SkipAll = errors.New("Skip everything and stop the walk")
+)
+
 // WalkFunc is the type of the function called for each node visited by Walk.
-// The path argument contains the prefix leading to this node.
-// If the function returns an error, walking stops and the error is returned.
+// The prefix argument is the prefix stored at this node.
+// If the function returns SkipSubtree, the more-specific prefixes of this one are not visited.
+// If it returns SkipAll, walking stops and the walk returns nil.
+// If the function returns any other error, walking stops and the error is returned as is.
 type WalkFunc func(prefix netip.Prefix, value interface{}) error
 
-// Walk traverses the tree in-order, calling walkFn for each node that contains a value.
-// The walk function receives the CIDR prefix as a string and the value stored at that node.
+// WalkDepthFunc is a WalkFunc that is also told how deep the prefix is nested: the number of stored
+// prefixes of the same family covering it, so top-level prefixes have depth 0. The depth of the node in
+// the tree is not passed, as it is just prefix.Bits() (plus 96 for IPv4), while the nesting cannot be
+// told from the prefix alone.
+type WalkDepthFunc func(prefix netip.Prefix, value interface{}, depth int) error
+
+// WalkV4 traverses the IPv4 part of the tree in-order, calling walkFn for each node that contains a value.
+// The walk function receives the unmapped IPv4 prefix and the value stored at that node.
 func (tree *Tree) WalkV4(walkFn WalkFunc) error {
 	tree.mutex.RLock()
 	defer tree.mutex.RUnlock()
+	return tree.walkV4(&walkState{}, func(prefix netip.Prefix, value interface{}, depth int) error {
+		return walkFn(prefix, value)
+	})
+}
+
+// WalkV4Context is WalkV4 passing each prefix's nesting depth. It stops with ctx.Err() once ctx is cancelled.
+// The tree is locked for reading during the whole walk.
+func (tree *Tree) WalkV4Context(ctx context.Context, walkFn WalkDepthFunc) error {
+	return tree.WalkV4Ordered(ctx, PreOrder, walkFn)
+}
+
+// WalkV4Ordered is WalkV4Context with a choice of traversal order.
+func (tree *Tree) WalkV4Ordered(ctx context.Context, order WalkOrder, walkFn WalkDepthFunc) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.walkV4(&walkState{ctx: ctx, order: order}, walkFn)
+}
 
+// walkV4 implements WalkV4 and WalkV4Ordered.
+func (tree *Tree) walkV4(w *walkState, walkFn WalkDepthFunc) error {
 	// Wrapper function to call walkFn only for IPv4 or IPv4-mapped IPv6 prefixes
-	walkFnWrapper := func(prefix netip.Prefix, value interface{}) error {
-		if prefix.Addr().Is4In6() {
+	w.fn = func(prefix netip.Prefix, value interface{}, depth int) error {
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
 			ip4prefix := netip.PrefixFrom(netip.AddrFrom4(prefix.Addr().As4()), prefix.Bits()-96)
-			// ip4prefix := netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
-			return walkFn(ip4prefix, value)
+			return walkFn(ip4prefix, value, depth)
 		} else if prefix.Addr().Is4() {
-			return walkFn(prefix, value)
+			return walkFn(prefix, value, depth)
 		}
 		return nil
 	}
 
-	initialPrefix := netip.PrefixFrom(netip.AddrFrom16([16]byte{}), 0)
-	return tree.walk(tree.root, initialPrefix, 0, walkFnWrapper)
+	// IPv4 prefixes all live below rootV4, unless it was never created
+	if tree.rootV4 != nil {
+		return skipAll(tree.walkFrom(w, navPos{n: tree.rootV4, addr: [16]byte{10: 0xff, 11: 0xff}, depth: 96}))
+	}
+	return skipAll(tree.walkFrom(w, navPos{n: tree.root}))
 }
 
+// WalkV6 is WalkV4 for IPv6 prefixes, leaving out the IPv4 subtree at ::ffff:0:0/96.
 func (tree *Tree) WalkV6(walkFn WalkFunc) error {
 	tree.mutex.RLock()
 	defer tree.mutex.RUnlock()
+	return tree.walkV6(&walkState{}, func(prefix netip.Prefix, value interface{}, depth int) error {
+		return walkFn(prefix, value)
+	})
+}
+
+// WalkV6Context is WalkV4Context for IPv6 prefixes.
+func (tree *Tree) WalkV6Context(ctx context.Context, walkFn WalkDepthFunc) error {
+	return tree.WalkV6Ordered(ctx, PreOrder, walkFn)
+}
 
+// WalkV6Ordered is WalkV6Context with a choice of traversal order.
+func (tree *Tree) WalkV6Ordered(ctx context.Context, order WalkOrder, walkFn WalkDepthFunc) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+	return tree.walkV6(&walkState{ctx: ctx, order: order}, walkFn)
+}
+
+// walkV6 implements WalkV6 and WalkV6Ordered.
+func (tree *Tree) walkV6(w *walkState, walkFn WalkDepthFunc) error {
 	// Wrapper function to call walkFn only for IPv6 prefixes
-	walkFnWrapper := func(prefix netip.Prefix, value interface{}) error {
-		if prefix.Addr().Is6() && !prefix.Addr().Is4In6() {
-			return walkFn(prefix, value)
+	w.fn = func(prefix netip.Prefix, value interface{}, depth int) error {
+		if prefix.Addr().Is6() && !(prefix.Addr().Is4In6() && prefix.Bits() >= 96) {
+			return walkFn(prefix, value, depth)
 		}
 		return nil
 	}
+	w.skipV4 = true
+	return skipAll(tree.walkFrom(w, navPos{n: tree.root}))
+}
+
+// WalkContext calls walkFn for every stored prefix of both families like Walk, passing each prefix's
+// nesting depth. It stops with ctx.Err() once ctx is cancelled.
+// The tree is locked for reading during the whole walk.
+func (tree *Tree) WalkContext(ctx context.Context, walkFn WalkDepthFunc) error {
+	return tree.WalkOrdered(ctx, PreOrder, walkFn)
+}
+
+// WalkOrder selects the order in which a walk visits prefixes.
+type WalkOrder int
+
+const (
+	// PreOrder visits a prefix before its more-specifics, the order used by Walk.
+	PreOrder WalkOrder = iota
+	// PostOrder visits the more-specifics of a prefix before the prefix itself.
+	// SkipSubtree has no effect, as the subtree has already been visited.
+	PostOrder
+	// BreadthFirst visits prefixes by increasing length in the tree, where IPv4 prefixes count 96 bits
+	// more, and prefixes of the same length in address order. SkipSubtree skips the more-specifics.
+	BreadthFirst
+)
+
+// WalkOrdered is WalkContext with a choice of traversal order, and so also Walk with one.
+// WalkV4Ordered and WalkV6Ordered do the same for a single family.
+func (tree *Tree) WalkOrdered(ctx context.Context, order WalkOrder, walkFn WalkDepthFunc) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	walkFnWrapper := func(prefix netip.Prefix, value interface{}, depth int) error {
+		return walkFn(userPrefix(prefix), value, depth)
+	}
+
+	return skipAll(tree.walkFrom(&walkState{ctx: ctx, order: order, fn: walkFnWrapper}, navPos{n: tree.root}))
+}
+
+// Walk calls walkFn once for every stored prefix of both families in a single traversal,
+// reporting IPv4 prefixes unmapped from ::ffff:0:0/96.
+//
+// Prefixes are visited in the tree's bit order: a prefix comes before its more-specifics, which come in
+// address order. As IPv4 is stored under ::ffff:0:0/96, that means IPv6 prefixes before ::ffff:0:0
+// (including ::/0) come first, then all IPv4 prefixes, then the remaining IPv6 prefixes.
+// Use WalkOrdered for another order.
+func (tree *Tree) Walk(walkFn WalkFunc) error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	walkFnWrapper := func(prefix netip.Prefix, value interface{}, depth int) error {
+		return walkFn(userPrefix(prefix), value)
+	}
 
-	// Initialize with a valid IPv6 prefix, e.g., an empty IPv6 address with a prefix length of 0
 	initialPrefix := netip.PrefixFrom(netip.AddrFrom16([16]byte{}), 0)
-	return tree.walk(tree.root, initialPrefix, 0, walkFnWrapper)
+	return skipAll(tree.walk(&walkState{fn: walkFnWrapper}, tree.root, initialPrefix, 0, 0))
 }
 
-func (tree *Tree) walk(n *Node, prefix netip.Prefix, depth int, walkFn WalkFunc) error {
+// skipAll turns the SkipAll sentinel into a clean stop.
+func skipAll(err error) error {
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkState holds what stays the same during a walk.
+type walkState struct {
+	ctx    context.Context // checked at every node if not nil
+	skipV4 bool            // leave out the subtree below rootV4
+	order  WalkOrder
+	fn     WalkDepthFunc
+}
+
+// walkFrom walks the subtree at pos in w.order.
+func (tree *Tree) walkFrom(w *walkState, pos navPos) error {
+	if w.order == BreadthFirst {
+		return tree.walkBreadthFirst(w, pos)
+	}
+	return tree.walk(w, pos.n, pos.prefix(), pos.depth, 0)
+}
+
+// walk calls w.fn for each live node below n in pre-order or post-order, as set by w.order. level is the number of live ancestors,
+// counted from rootV4 for IPv4 prefixes. Errors from w.fn, including SkipAll, are returned unwrapped.
+func (tree *Tree) walk(w *walkState, n *Node, prefix netip.Prefix, depth, level int) (err error) {
 	if n == nil {
 		return errors.New("node is nil")
 	}
+	if w.ctx != nil {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if n == tree.rootV4 {
+		if w.skipV4 {
+			return nil
+		}
+		// IPv4 prefixes are not nested under the IPv6 prefixes above ::ffff:0:0/96
+		level = 0
+	}
+
+	// Process current node if it has a value
+	live := tree.live(n)
+	if live && w.order == PreOrder {
+		err := w.fn(prefix, n.value, level)
+		if err == SkipSubtree {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if live && w.order == PostOrder {
+		defer func() {
+			if err == nil {
+				if err = w.fn(prefix, n.value, level); err == SkipSubtree {
+					err = nil
+				}
+			}
+		}()
+	}
+	childLevel := level
+	if live {
+		childLevel++
+	}
 
 	// Don't go deeper than 128 bits (the full IPv6 length).
 	// For IPv4-mapped addresses, bits [0..95] are ::ffff: and bits [96..127] are the real IPv4.
 	// Once depth == 128, we have exhausted all bits.
 	const maxDepth = 128
 	if depth >= maxDepth {
-		// If there's a value here, report it; otherwise just return.
-		if n.value != nil {
-			if err := walkFn(prefix, n.value); err != nil {
-				return fmt.Errorf("error processing node value at max depth: %w", err)
-			}
-		}
 		return nil
 	}
 
-	// Process current node if it has a value
-	if n.value != nil {
-		if err := walkFn(prefix, n.value); err != nil {
-			return fmt.Errorf("error processing node value: %w", err)
-		}
-	}
-
 	// Walk left subtree
 	if n.left != nil {
 		leftAddr, ok := setBitAtDepth(prefix.Addr(), depth, false)
@@ -858,8 +1217,8 @@ func (tree *Tree) walk(n *Node, prefix netip.Prefix, depth int, walkFn WalkFunc)
 			return fmt.Errorf("failed to set bit at depth %d", depth)
 		}
 		leftPrefix := netip.PrefixFrom(leftAddr, depth+1)
-		if err := tree.walk(n.left, leftPrefix, depth+1, walkFn); err != nil {
-			return fmt.Errorf("error walking left subtree: %w", err)
+		if err := tree.walk(w, n.left, leftPrefix, depth+1, childLevel); err != nil {
+			return err
 		}
 	}
 
@@ -870,14 +1229,59 @@ func (tree *Tree) walk(n *Node, prefix netip.Prefix, depth int, walkFn WalkFunc)
 			return fmt.Errorf("failed to set bit at depth %d", depth)
 		}
 		rightPrefix := netip.PrefixFrom(rightAddr, depth+1)
-		if err := tree.walk(n.right, rightPrefix, depth+1, walkFn); err != nil {
-			return fmt.Errorf("error walking right subtree: %w", err)
+		if err := tree.walk(w, n.right, rightPrefix, depth+1, childLevel); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// walkBreadthFirst calls w.fn for each live node in the subtree at start, level by level.
+func (tree *Tree) walkBreadthFirst(w *walkState, start navPos) error {
+	type item struct {
+		pos   navPos
+		level int
+	}
+	queue := []item{{pos: start}}
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+		if w.ctx != nil {
+			if err := w.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if it.pos.n == tree.rootV4 {
+			if w.skipV4 {
+				continue
+			}
+			it.level = 0
+		}
+
+		if tree.live(it.pos.n) {
+			err := w.fn(it.pos.prefix(), it.pos.n.value, it.level)
+			if err == SkipSubtree {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			it.level++
+		}
+		if it.pos.depth == 128 {
+			continue
+		}
+		if it.pos.n.left != nil {
+			queue = append(queue, item{pos: it.pos.left(), level: it.level})
+		}
+		if it.pos.n.right != nil {
+			queue = append(queue, item{pos: it.pos.right(), level: it.level})
+		}
+	}
+	return nil
+}
+
 // Helper function to set a specific bit in the address at a given depth
 func setBitAtDepth(addr netip.Addr, depth int, isRight bool) (netip.Addr, bool) {
 	// Convert the address to a mutable byte slice
@@ -1,7 +1,11 @@
 package nradix
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/netip"
+	"strings"
 	"testing"
 )
 
@@ -2819,3 +2823,190 @@ func TestSetCIDRNetIPPrefix(t *testing.T) {
 	// 	t.Error("Expected error for nil tree, got none")
 	// }
 }
+
+// TestWalkControl tests SkipSubtree, SkipAll, nesting depths and unwrapped errors.
+func TestWalkControl(t *testing.T) {
+	tr := NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	tr.SetCIDRString("10.1.0.0/16", 2, false)
+	tr.SetCIDRString("10.1.1.0/24", 3, false)
+	tr.SetCIDRString("172.16.0.0/12", 4, false)
+	tr.SetCIDRString("::/0", 5, false)
+	tr.SetCIDRString("2001:db8::/32", 6, false)
+
+	depths := map[string]int{}
+	record := func(prefix netip.Prefix, value interface{}, depth int) error {
+		depths[prefix.String()] = depth
+		return nil
+	}
+	if err := tr.WalkV4Context(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.WalkV6Context(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"10.0.0.0/8": 0, "10.1.0.0/16": 1, "10.1.1.0/24": 2, "172.16.0.0/12": 0, "::/0": 0, "2001:db8::/32": 1}
+	for prefix, depth := range want {
+		if got, ok := depths[prefix]; !ok || got != depth {
+			t.Errorf("%s: expected depth %d, got %d (visited %v)", prefix, depth, got, ok)
+		}
+	}
+
+	var visited []string
+	err := tr.WalkV4(func(prefix netip.Prefix, value interface{}) error {
+		visited = append(visited, prefix.String())
+		if prefix.String() == "10.1.0.0/16" {
+			return SkipSubtree
+		}
+		if prefix.String() == "172.16.0.0/12" {
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected SkipAll to stop cleanly, got %v", err)
+	}
+	if strings.Join(visited, " ") != "10.0.0.0/8 10.1.0.0/16 172.16.0.0/12" {
+		t.Errorf("Wrong walk: %v", visited)
+	}
+
+	errStop := errors.New("stop")
+	if err := tr.WalkV6(func(netip.Prefix, interface{}) error { return errStop }); err != errStop {
+		t.Errorf("Expected the callback error unwrapped, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err = tr.WalkV4Context(ctx, func(netip.Prefix, interface{}, int) error {
+		count++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || count != 1 {
+		t.Errorf("Expected the walk to stop after cancel, got %v after %d", err, count)
+	}
+}
+
+// TestWalkBothFamilies tests that Walk reports every prefix once, in the documented order.
+func TestWalkBothFamilies(t *testing.T) {
+	tr := NewTree(0)
+	for _, cidr := range []string{"2001:db8::/32", "10.0.0.0/8", "::/0", "::1/128", "192.168.0.0/16", "10.1.0.0/16"} {
+		tr.SetCIDRString(cidr, cidr, false)
+	}
+
+	var visited []string
+	err := tr.Walk(func(prefix netip.Prefix, value interface{}) error {
+		if prefix.String() != value.(string) {
+			t.Errorf("Expected prefix %s, got %s", value, prefix)
+		}
+		visited = append(visited, prefix.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(visited, " "); got != "::/0 ::1/128 10.0.0.0/8 10.1.0.0/16 192.168.0.0/16 2001:db8::/32" {
+		t.Errorf("Wrong walk order: %s", got)
+	}
+
+	depths := map[string]int{}
+	err = tr.WalkContext(context.Background(), func(prefix netip.Prefix, value interface{}, depth int) error {
+		depths[prefix.String()] = depth
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"::/0": 0, "::1/128": 1, "10.0.0.0/8": 0, "10.1.0.0/16": 1, "192.168.0.0/16": 0, "2001:db8::/32": 1}
+	for prefix, depth := range want {
+		if got, ok := depths[prefix]; !ok || got != depth {
+			t.Errorf("%s: expected depth %d, got %d (visited %v)", prefix, depth, got, ok)
+		}
+	}
+
+	count := 0
+	tr.WalkV6(func(prefix netip.Prefix, value interface{}) error {
+		count++
+		return nil
+	})
+	if count != 3 {
+		t.Errorf("Expected WalkV6 to visit 3 prefixes, got %d", count)
+	}
+}
+
+// TestWalkOrders tests the order of prefixes for each WalkOrder.
+func TestWalkOrders(t *testing.T) {
+	tr := NewTree(0)
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.1.0/24", "10.2.0.0/16", "192.168.0.0/16"} {
+		tr.SetCIDRString(cidr, cidr, false)
+	}
+
+	tests := []struct {
+		order WalkOrder
+		want  string
+	}{
+		{PreOrder, "10.0.0.0/8:0 10.1.0.0/16:1 10.1.1.0/24:2 10.2.0.0/16:1 192.168.0.0/16:0"},
+		{PostOrder, "10.1.1.0/24:2 10.1.0.0/16:1 10.2.0.0/16:1 10.0.0.0/8:0 192.168.0.0/16:0"},
+		{BreadthFirst, "10.0.0.0/8:0 10.1.0.0/16:1 10.2.0.0/16:1 192.168.0.0/16:0 10.1.1.0/24:2"},
+	}
+	for _, tt := range tests {
+		var visited []string
+		err := tr.WalkOrdered(context.Background(), tt.order, func(prefix netip.Prefix, value interface{}, depth int) error {
+			visited = append(visited, fmt.Sprintf("%s:%d", prefix, depth))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(visited, " "); got != tt.want {
+			t.Errorf("Order %d: expected %s, got %s", tt.order, tt.want, got)
+		}
+	}
+}
+
+// TestWalkFamilyOrders tests that WalkV4Ordered and WalkV6Ordered keep to their family in every order.
+func TestWalkFamilyOrders(t *testing.T) {
+	tr := NewTree(0)
+	for _, cidr := range []string{"::/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.1.0/24", "192.168.0.0/16", "2001:db8::/32", "2001:db8:1::/48", "2001:db9::/32"} {
+		tr.SetCIDRString(cidr, cidr, false)
+	}
+
+	tests := []struct {
+		order  WalkOrder
+		wantV4 string
+		wantV6 string
+	}{
+		{PreOrder, "10.0.0.0/8 10.1.0.0/16 10.1.1.0/24 192.168.0.0/16", "::/0 2001:db8::/32 2001:db8:1::/48 2001:db9::/32"},
+		{PostOrder, "10.1.1.0/24 10.1.0.0/16 10.0.0.0/8 192.168.0.0/16", "2001:db8:1::/48 2001:db8::/32 2001:db9::/32 ::/0"},
+		{BreadthFirst, "10.0.0.0/8 10.1.0.0/16 192.168.0.0/16 10.1.1.0/24", "::/0 2001:db8::/32 2001:db9::/32 2001:db8:1::/48"},
+	}
+	for _, tt := range tests {
+		var v4, v6 []string
+		err := tr.WalkV4Ordered(context.Background(), tt.order, func(prefix netip.Prefix, value interface{}, depth int) error {
+			v4 = append(v4, prefix.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tr.WalkV6Ordered(context.Background(), tt.order, func(prefix netip.Prefix, value interface{}, depth int) error {
+			v6 = append(v6, prefix.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(v4, " "); got != tt.wantV4 {
+			t.Errorf("Order %d: expected IPv4 %s, got %s", tt.order, tt.wantV4, got)
+		}
+		if got := strings.Join(v6, " "); got != tt.wantV6 {
+			t.Errorf("Order %d: expected IPv6 %s, got %s", tt.order, tt.wantV6, got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tr.WalkV4Ordered(ctx, BreadthFirst, func(netip.Prefix, interface{}, int) error { return nil }); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
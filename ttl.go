@@ -0,0 +1,147 @@
+package nradix
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// SetClock replaces the clock used to decide whether TTL entries have expired.
+// Passing nil restores time.Now. This is mainly useful for tests.
+func (tree *Tree) SetClock(now func() time.Time) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	tree.now = now
+}
+
+// clock returns the current time according to the tree's clock.
+func (tree *Tree) clock() time.Time {
+	if tree.now != nil {
+		return tree.now()
+	}
+	return time.Now()
+}
+
+// expired reports whether the node carries a TTL that has already passed.
+// The clock is only consulted for nodes that were stored with a TTL.
+func (tree *Tree) expired(n *Node) bool {
+	expires, ok := tree.expires[n]
+	return ok && expires <= tree.clock().UnixNano()
+}
+
+// live reports whether the node holds a value that lookups should see.
+func (tree *Tree) live(n *Node) bool {
+	return n.value != nil && !tree.expired(n)
+}
+
+// SetWithTTL sets a value for the prefix that expires after ttl, overwriting any existing value and TTL.
+// Expired entries are treated as absent by the FindCIDR* functions until they are removed,
+// either by SweepExpired or by a sweeper started with StartSweeper. A ttl <= 0 stores the value without expiry.
+func (tree *Tree) SetWithTTL(prefix netip.Prefix, val interface{}, ttl time.Duration) error {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	if !prefix.IsValid() {
+		return ErrBadIP
+	}
+
+	var expires int64
+	if ttl > 0 {
+		expires = tree.clock().Add(ttl).UnixNano()
+	}
+	key, mask := prefixKeyMask(prefix)
+	return tree.insert6Expiring(key, mask, val, true, expires)
+}
+
+// setExpiry records when the value of n expires in unix nanoseconds, zero for never.
+func (tree *Tree) setExpiry(n *Node, expires int64) {
+	if expires == 0 {
+		delete(tree.expires, n)
+		return
+	}
+	if tree.expires == nil {
+		tree.expires = make(map[*Node]int64)
+	}
+	tree.expires[n] = expires
+}
+
+// dropExpired takes an expired value that is about to be overwritten out of the indexes and counts.
+// It is not announced, as the value already counts as absent; the overwrite is announced as an add.
+func (tree *Tree) dropExpired(prefix netip.Prefix, old interface{}) {
+	ev := Event{Type: EventDelete, Prefix: userPrefix(prefix), Old: old}
+	tree.countPrefix(ev)
+	if tree.reverse != nil {
+		tree.reverse.update(ev)
+	}
+	for _, ix := range tree.labels {
+		ix.update(ev)
+	}
+}
+
+// SweepExpired removes every expired entry from the tree using the regular delete path.
+// It returns the number of entries removed.
+func (tree *Tree) SweepExpired() int {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	// collect first, deleting trims nodes and would disturb the traversal
+	var expired []netip.Prefix
+	stack := []*Node{tree.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.value != nil && tree.expired(n) {
+			expired = append(expired, n.prefix)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+		if n.right != nil {
+			stack = append(stack, n.right)
+		}
+	}
+
+	removed := 0
+	for _, prefix := range expired {
+		key, mask := prefixKeyMask(prefix)
+		if tree.deleteIPv6(key, mask, false) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// DefaultSweepInterval is used by StartSweeper when it is given an interval <= 0.
+const DefaultSweepInterval = time.Minute
+
+// StartSweeper starts a goroutine that calls SweepExpired every interval, or every DefaultSweepInterval
+// if interval is not positive.
+// The returned function stops the sweeper and waits for it to exit; it is safe to call more than once.
+func (tree *Tree) StartSweeper(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tree.SweepExpired()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
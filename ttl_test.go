@@ -0,0 +1,182 @@
+package nradix
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for TTL tests.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// TestSetWithTTL tests that expired entries are ignored by lookups and removed by the sweep.
+func TestSetWithTTL(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	if err := tr.SetWithTTL(netip.MustParsePrefix("10.1.0.0/16"), 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.SetWithTTL(netip.MustParsePrefix("2001:db8::/32"), 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := tr.FindCIDRString("10.1.2.3")
+	if err != nil || info.(int) != 2 {
+		t.Errorf("Wrong value before expiry, expected 2, got %v (%v)", info, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+
+	info, err = tr.FindCIDRString("10.1.2.3")
+	if err != nil || info.(int) != 1 {
+		t.Errorf("Wrong value after expiry, expected 1, got %v (%v)", info, err)
+	}
+	info, err = tr.FindCIDRNetIPAddr(netip.MustParseAddr("2001:db8::1"))
+	if err != nil || info != nil {
+		t.Errorf("Expected expired IPv6 entry to be absent, got %v (%v)", info, err)
+	}
+
+	// an expired entry does not block a non-overwriting set
+	if err := tr.SetCIDRString("2001:db8::/32", 4, false); err != nil {
+		t.Errorf("Expected expired entry to be replaceable, got %v", err)
+	}
+
+	if err := tr.SetWithTTL(netip.MustParsePrefix("10.1.0.0/16"), 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if removed := tr.SweepExpired(); removed != 1 {
+		t.Errorf("Expected 1 expired entry to be swept, got %d", removed)
+	}
+	info, _ = tr.FindCIDRString("2001:db8::1")
+	if info.(int) != 4 {
+		t.Errorf("Wrong value, expected 4, got %v", info)
+	}
+}
+
+// TestStartSweeper tests that the background sweeper removes expired entries.
+func TestStartSweeper(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	tr.SetWithTTL(netip.MustParsePrefix("10.0.0.0/8"), 1, time.Second)
+	clock.Advance(time.Minute)
+
+	stop := tr.StartSweeper(time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		tr.mutex.RLock()
+		node := tr.lookup6(prefixKeyMask(netip.MustParsePrefix("10.0.0.0/8")))
+		tr.mutex.RUnlock()
+		if node == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("Sweeper did not remove the expired entry")
+}
+
+// TestStartSweeperDefault tests that a non-positive interval falls back to the default instead of panicking.
+func TestStartSweeperDefault(t *testing.T) {
+	tr := NewTree(0)
+	tr.StartSweeper(0)()
+	tr.StartSweeper(-time.Second)()
+}
+
+// TestOverwriteExpired tests that replacing an expired value is reported as an add.
+func TestOverwriteExpired(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	tr.SetReverseIndex(func(v interface{}) interface{} { return v })
+
+	var events []Event
+	tr.Watch(netip.MustParsePrefix("::/0"), func(ev Event) { events = append(events, ev) })
+
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	tr.SetWithTTL(prefix, 1, time.Minute)
+	clock.Advance(2 * time.Minute)
+	tr.SetCIDRString("10.0.0.0/8", 2, false)
+
+	if len(events) != 2 || events[1].Type != EventAdd || events[1].Old != nil {
+		t.Errorf("Expected an add for the overwrite, got %+v", events)
+	}
+	if got := tr.PrefixesFor(1); len(got) != 0 {
+		t.Errorf("Expected the expired value to leave the index, got %v", got)
+	}
+	if st := tr.Stats(); st.PrefixesV4 != 1 {
+		t.Errorf("Expected 1 IPv4 prefix, got %d", st.PrefixesV4)
+	}
+}
+
+// TestPrefixesForExpired tests that the reverse index does not return expired entries before they are swept.
+func TestPrefixesForExpired(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	tr.SetReverseIndex(func(v interface{}) interface{} { return v })
+
+	tr.SetWithTTL(netip.MustParsePrefix("10.0.0.0/8"), 1, time.Minute)
+	tr.SetWithTTL(netip.MustParsePrefix("2001:db8::/32"), 1, time.Hour)
+	tr.SetCIDRString("192.168.0.0/16", 1, false)
+
+	clock.Advance(2 * time.Minute)
+	got := tr.PrefixesFor(1)
+	want := []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16"), netip.MustParsePrefix("2001:db8::/32")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestTTLSideTable tests that expiry is only tracked once SetWithTTL is used and does not outlive its node.
+func TestTTLSideTable(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	if tr.expires != nil {
+		t.Fatal("Expected no expiry table before SetWithTTL")
+	}
+
+	tr.SetWithTTL(netip.MustParsePrefix("10.1.0.0/16"), 2, time.Second)
+	tr.DeleteCIDRString("10.1.0.0/16")
+	if len(tr.expires) != 0 {
+		t.Errorf("Expected the expiry to go with the deleted node, got %d entries", len(tr.expires))
+	}
+
+	// the freed node is reused without the old expiry
+	tr.SetCIDRString("10.2.0.0/16", 3, false)
+	clock.Advance(time.Minute)
+	if info, err := tr.FindCIDRString("10.2.0.1"); err != nil || info != 3 {
+		t.Errorf("Expected 3, got %v (%v)", info, err)
+	}
+
+	tr.SetWithTTL(netip.MustParsePrefix("10.3.0.0/16"), 4, time.Second)
+	tr.SetCIDRString("10.3.0.0/16", 5, true)
+	clock.Advance(time.Minute)
+	if info, err := tr.FindCIDRString("10.3.0.1"); err != nil || info != 5 {
+		t.Errorf("Expected the overwrite to clear the TTL, got %v (%v)", info, err)
+	}
+}
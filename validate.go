@@ -0,0 +1,88 @@
+package nradix
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrTreeCorrupt is wrapped by the errors Validate returns.
+var ErrTreeCorrupt = errors.New("Tree invariant violated")
+
+// Validate checks the internal consistency of the tree and returns an error wrapping ErrTreeCorrupt
+// that describes the first problem found, or nil. It checks that
+//   - every child points back at its parent and no node is reachable twice,
+//   - the prefix recorded in each node holding a value matches the node's position,
+//   - rootV4 is still attached to the tree at ::ffff:0:0/96,
+//   - no node on the free list is still reachable from the root.
+//
+// Validate visits every node, so it is meant for tests and debugging rather than the hot path.
+func (tree *Tree) Validate() error {
+	tree.mutex.RLock()
+	defer tree.mutex.RUnlock()
+
+	if tree.root == nil {
+		return fmt.Errorf("%w: no root node", ErrTreeCorrupt)
+	}
+	if tree.root.parent != nil {
+		return fmt.Errorf("%w: root node has a parent", ErrTreeCorrupt)
+	}
+
+	v4 := mappedPrefix(netip.PrefixFrom(netip.IPv4Unspecified(), 0))
+	reachable := make(map[*Node]struct{})
+	rootV4Seen := false
+
+	stack := []navPos{{n: tree.root}}
+	for len(stack) > 0 {
+		pos := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n := pos.n
+
+		if _, ok := reachable[n]; ok {
+			return fmt.Errorf("%w: node at %s is reachable more than once", ErrTreeCorrupt, pos.prefix())
+		}
+		reachable[n] = struct{}{}
+
+		if n == tree.rootV4 {
+			if pos.prefix() != v4 {
+				return fmt.Errorf("%w: rootV4 is attached at %s instead of %s", ErrTreeCorrupt, pos.prefix(), v4)
+			}
+			rootV4Seen = true
+		}
+		if n.value != nil && (!n.prefix.IsValid() || userPrefix(n.prefix) != userPrefix(pos.prefix())) {
+			return fmt.Errorf("%w: node at %s records prefix %s", ErrTreeCorrupt, pos.prefix(), n.prefix)
+		}
+
+		if pos.depth == 128 {
+			if n.left != nil || n.right != nil {
+				return fmt.Errorf("%w: node at %s has children below 128 bits", ErrTreeCorrupt, pos.prefix())
+			}
+			continue
+		}
+		for _, child := range []navPos{pos.left(), pos.right()} {
+			if child.n == nil {
+				continue
+			}
+			if child.n.parent != n {
+				return fmt.Errorf("%w: node at %s does not point back at its parent", ErrTreeCorrupt, child.prefix())
+			}
+			stack = append(stack, child)
+		}
+	}
+
+	if tree.rootV4 != nil && !rootV4Seen {
+		return fmt.Errorf("%w: rootV4 is not attached to the tree", ErrTreeCorrupt)
+	}
+
+	free := make(map[*Node]struct{})
+	for n := tree.free; n != nil; n = n.right {
+		if _, ok := free[n]; ok {
+			return fmt.Errorf("%w: free list has a cycle", ErrTreeCorrupt)
+		}
+		free[n] = struct{}{}
+		if _, ok := reachable[n]; ok {
+			return fmt.Errorf("%w: node on the free list is still in the tree", ErrTreeCorrupt)
+		}
+	}
+	return nil
+}
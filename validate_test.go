@@ -0,0 +1,111 @@
+package nradix
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestValidate tests that a tree stays valid through inserts and deletes and that corruption is caught.
+func TestValidate(t *testing.T) {
+	tr := NewTree(0)
+	for i := 0; i < 64; i++ {
+		tr.SetCIDRString(fmt.Sprintf("10.%d.0.0/16", i), i, false)
+		tr.SetCIDRString(fmt.Sprintf("2001:db8:%x::/48", i), i, false)
+	}
+	tr.SetCIDRString("0.0.0.0/0", "default", false)
+	for i := 0; i < 64; i += 2 {
+		tr.DeleteCIDRString(fmt.Sprintf("10.%d.0.0/16", i))
+		tr.DeleteCIDRString(fmt.Sprintf("2001:db8:%x::/48", i))
+	}
+	tr.DeleteCIDRString("0.0.0.0/0")
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a node handed to the free list while still linked in
+	n := tr.rootV4.left
+	n.right, tr.free = tr.free, n
+	if err := tr.Validate(); !errors.Is(err, ErrTreeCorrupt) {
+		t.Errorf("Expected ErrTreeCorrupt for a reachable free node, got %v", err)
+	}
+
+	tr = NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	tr.rootV4 = &Node{}
+	if err := tr.Validate(); !errors.Is(err, ErrTreeCorrupt) {
+		t.Errorf("Expected ErrTreeCorrupt for a detached rootV4, got %v", err)
+	}
+
+	tr = NewTree(0)
+	tr.SetCIDRString("10.0.0.0/8", 1, false)
+	tr.rootV4.parent = nil
+	if err := tr.Validate(); !errors.Is(err, ErrTreeCorrupt) {
+		t.Errorf("Expected ErrTreeCorrupt for a broken parent link, got %v", err)
+	}
+}
+
+// TestSweepKeepsIPv4Root tests that sweeping the last IPv4 entry keeps the IPv4 shortcut usable.
+func TestSweepKeepsIPv4Root(t *testing.T) {
+	tr := NewTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr.SetClock(clock.Now)
+	rootV4 := tr.rootV4
+
+	tr.SetWithTTL(netip.MustParsePrefix("192.168.1.0/24"), 1, time.Second)
+	clock.Advance(time.Minute)
+	if removed := tr.SweepExpired(); removed != 1 {
+		t.Fatalf("Expected 1 expired entry to be swept, got %d", removed)
+	}
+	if tr.rootV4 != rootV4 || rootV4.parent == nil {
+		t.Fatal("IPv4 root was detached by the sweep")
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.SetCIDRString("192.168.2.0/24", 2, false)
+	info, err := tr.FindCIDRString("192.168.2.1")
+	if err != nil || info == nil || info.(int) != 2 {
+		t.Errorf("Wrong value after sweep, expected 2, got %v (%v)", info, err)
+	}
+}
+
+// TestDeleteRangeKeepsIPv4Root tests that whole-range deletes above ::ffff:0:0/96 leave the tree valid.
+func TestDeleteRangeKeepsIPv4Root(t *testing.T) {
+	for _, cidr := range []string{"::ffff:0:0/95", "::/0"} {
+		tr := NewTree(0)
+		tr.SetCIDRString("::/0", "default6", false)
+		tr.SetCIDRString("::1/128", "lo", false)
+		tr.SetCIDRString("10.0.0.0/8", 1, false)
+		tr.SetCIDRString("10.1.0.0/16", 2, false)
+		freeList := func() int {
+			n := 0
+			for f := tr.free; f != nil; f = f.right {
+				n++
+			}
+			return n
+		}
+		free := freeList()
+
+		if err := tr.DeleteWholeRangeCIDR(cidr); err != nil {
+			t.Fatalf("%s: %v", cidr, err)
+		}
+		if err := tr.Validate(); err != nil {
+			t.Errorf("%s: %v", cidr, err)
+		}
+		if n := freeList(); n <= free {
+			t.Errorf("%s: expected the deleted nodes to be freed, free list went from %d to %d", cidr, free, n)
+		}
+		if info, _ := tr.FindCIDRString("10.1.1.1"); info != nil {
+			t.Errorf("%s: expected 10.1.1.1 to be gone, got %v", cidr, info)
+		}
+
+		tr.SetCIDRString("192.168.0.0/16", 3, false)
+		if info, _ := tr.FindCIDRString("192.168.1.1"); info != 3 {
+			t.Errorf("%s: wrong value after the delete, expected 3, got %v", cidr, info)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package nradix
+
+import (
+	"errors"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrVersionCompacted = errors.New("Version has been compacted")
+	ErrVersionUnknown   = errors.New("Version has not been committed")
+)
+
+// VersionedTree is a Tree that remembers earlier states of its contents.
+// Changes made through the embedded Tree become visible to FindAt and WalkAt once Commit is called,
+// which bumps the version number. Version 0 is the empty tree before the first commit.
+type VersionedTree struct {
+	*Tree
+
+	mu      sync.RWMutex
+	hist    *Tree // per-prefix histories, stored at the same positions as in Tree
+	version uint64
+	oldest  uint64
+	commits []versionCommit
+	retain  int
+}
+
+// versionCommit records when a version was committed.
+type versionCommit struct {
+	version uint64
+	at      time.Time
+}
+
+// versionedValue is the value a prefix took from version on, nil when it was deleted.
+type versionedValue struct {
+	version uint64
+	value   interface{}
+}
+
+// versionHistory is the list of values a prefix has had, oldest first.
+type versionHistory struct {
+	entries []versionedValue
+}
+
+// at returns the value the prefix had at the given version.
+func (h *versionHistory) at(version uint64) interface{} {
+	i := sort.Search(len(h.entries), func(i int) bool { return h.entries[i].version > version })
+	if i == 0 {
+		return nil
+	}
+	return h.entries[i-1].value
+}
+
+// NewVersionedTree creates an empty versioned tree. If retain is positive, only the last retain
+// versions are kept answerable and older history is compacted on Commit.
+func NewVersionedTree(retain int) *VersionedTree {
+	v := &VersionedTree{Tree: NewTree(0), hist: NewTree(0), retain: retain}
+	v.commits = append(v.commits, versionCommit{version: 0})
+	v.Watch(netip.PrefixFrom(netip.IPv6Unspecified(), 0), v.record)
+	return v
+}
+
+// record stores an uncommitted change, it runs with the tree locked for writing.
+func (v *VersionedTree) record(ev Event) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	pending := v.version + 1
+	key, mask := prefixKeyMask(ev.Prefix)
+	node := v.hist.lookup6(key, mask)
+	if node == nil || node.value == nil {
+		if ev.New == nil {
+			return
+		}
+		v.hist.insert6(key, mask, &versionHistory{entries: []versionedValue{{version: pending, value: ev.New}}}, true)
+		return
+	}
+
+	h := node.value.(*versionHistory)
+	if last := &h.entries[len(h.entries)-1]; last.version == pending {
+		last.value = ev.New
+		return
+	}
+	h.entries = append(h.entries, versionedValue{version: pending, value: ev.New})
+}
+
+// Commit makes the changes since the previous commit visible under a new version number and returns it.
+func (v *VersionedTree) Commit() uint64 {
+	v.Tree.mutex.RLock()
+	now := v.clock()
+	v.Tree.mutex.RUnlock()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.version++
+	v.commits = append(v.commits, versionCommit{version: v.version, at: now})
+	if v.retain > 0 && v.version >= uint64(v.retain) && v.version-uint64(v.retain)+1 > v.oldest {
+		v.compact(v.version - uint64(v.retain) + 1)
+	}
+	return v.version
+}
+
+// Version returns the last committed version.
+func (v *VersionedTree) Version() uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.version
+}
+
+// VersionAt returns the version that was current at time t.
+// It returns false if t is before the oldest version still retained.
+func (v *VersionedTree) VersionAt(t time.Time) (uint64, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	i := sort.Search(len(v.commits), func(i int) bool { return v.commits[i].at.After(t) })
+	if i == 0 {
+		return 0, false
+	}
+	return v.commits[i-1].version, true
+}
+
+// checkVersion reports whether version can be answered.
+func (v *VersionedTree) checkVersion(version uint64) error {
+	if version < v.oldest {
+		return ErrVersionCompacted
+	}
+	if version > v.version {
+		return ErrVersionUnknown
+	}
+	return nil
+}
+
+// FindAt returns the value of the longest prefix covering addr as of the given version.
+func (v *VersionedTree) FindAt(addr netip.Addr, version uint64) (interface{}, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if err := v.checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	// IPv4 lookups start at ::ffff:0:0/96 like find32WithNode does
+	minDepth := 0
+	if addr.Is4() {
+		minDepth = 96
+	}
+	key := addr.As16()
+
+	var value interface{}
+	node := v.hist.root
+	for depth := 0; node != nil; depth++ {
+		if node.value != nil && depth >= minDepth {
+			if val := node.value.(*versionHistory).at(version); val != nil {
+				value = val
+			}
+		}
+		if depth == 128 {
+			break
+		}
+		if key[depth/8]&(0x80>>(depth%8)) != 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+	}
+	return value, nil
+}
+
+// WalkAt calls walkFn for every prefix that held a value at the given version, in the tree's bit order.
+func (v *VersionedTree) WalkAt(version uint64, walkFn WalkFunc) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if err := v.checkVersion(version); err != nil {
+		return err
+	}
+
+	stack := []*Node{v.hist.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.value != nil {
+			if val := n.value.(*versionHistory).at(version); val != nil {
+				if err := walkFn(userPrefix(n.prefix), val); err != nil {
+					return err
+				}
+			}
+		}
+		if n.right != nil {
+			stack = append(stack, n.right)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+	}
+	return nil
+}
+
+// Compact drops history that is no longer needed to answer the retained versions.
+// It is called automatically by Commit when a retention is configured.
+func (v *VersionedTree) Compact(oldest uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if oldest > v.version {
+		oldest = v.version
+	}
+	if oldest > v.oldest {
+		v.compact(oldest)
+	}
+}
+
+// compact forgets every version before oldest, keeping for each prefix the value it had at oldest.
+func (v *VersionedTree) compact(oldest uint64) {
+	var gone []netip.Prefix
+	stack := []*Node{v.hist.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.value != nil {
+			h := n.value.(*versionHistory)
+			i := sort.Search(len(h.entries), func(i int) bool { return h.entries[i].version > oldest })
+			if i > 1 {
+				h.entries = append(h.entries[:0], h.entries[i-1:]...)
+			}
+			if len(h.entries) == 1 && h.entries[0].value == nil && h.entries[0].version <= oldest {
+				gone = append(gone, n.prefix)
+			}
+		}
+		if n.right != nil {
+			stack = append(stack, n.right)
+		}
+		if n.left != nil {
+			stack = append(stack, n.left)
+		}
+	}
+	for _, prefix := range gone {
+		key, mask := prefixKeyMask(prefix)
+		v.hist.deleteIPv6(key, mask, false)
+	}
+
+	i := sort.Search(len(v.commits), func(i int) bool { return v.commits[i].version >= oldest })
+	v.commits = append(v.commits[:0], v.commits[i:]...)
+	v.oldest = oldest
+}
@@ -0,0 +1,84 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestVersionedTree tests point-in-time lookups and walks across commits.
+func TestVersionedTree(t *testing.T) {
+	v := NewVersionedTree(0)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	v.SetClock(clock.Now)
+
+	v.SetCIDRString("10.0.0.0/8", 1, false)
+	v.SetCIDRString("203.0.113.0/24", "a", false)
+	v1 := v.Commit()
+
+	clock.Advance(time.Hour)
+	v.SetCIDRString("203.0.113.0/24", "b", true)
+	v.DeleteCIDRString("10.0.0.0/8")
+	v2 := v.Commit()
+
+	// uncommitted changes are not visible to FindAt
+	v.SetCIDRString("203.0.113.0/24", "c", true)
+
+	addr := netip.MustParseAddr("203.0.113.7")
+	for _, tc := range []struct {
+		version uint64
+		want    interface{}
+	}{{0, nil}, {v1, "a"}, {v2, "b"}} {
+		got, err := v.FindAt(addr, tc.version)
+		if err != nil || got != tc.want {
+			t.Errorf("FindAt version %d: expected %v, got %v (%v)", tc.version, tc.want, got, err)
+		}
+	}
+	if got, _ := v.FindAt(netip.MustParseAddr("10.1.1.1"), v1); got != 1 {
+		t.Errorf("Expected 1 at version %d, got %v", v1, got)
+	}
+	if got, _ := v.FindAt(netip.MustParseAddr("10.1.1.1"), v2); got != nil {
+		t.Errorf("Expected deleted prefix to be absent at version %d, got %v", v2, got)
+	}
+	if _, err := v.FindAt(addr, v2+1); err != ErrVersionUnknown {
+		t.Errorf("Expected ErrVersionUnknown, got %v", err)
+	}
+
+	if version, ok := v.VersionAt(time.Unix(1700000000, 0).Add(30 * time.Minute)); !ok || version != v1 {
+		t.Errorf("Expected version %d half way between commits, got %d (%v)", v1, version, ok)
+	}
+
+	walked := map[netip.Prefix]interface{}{}
+	v.WalkAt(v1, func(prefix netip.Prefix, value interface{}) error {
+		walked[prefix] = value
+		return nil
+	})
+	if len(walked) != 2 || walked[netip.MustParsePrefix("10.0.0.0/8")] != 1 {
+		t.Errorf("Unexpected walk at version %d: %v", v1, walked)
+	}
+}
+
+// TestVersionedTreeRetention tests that old versions are compacted away.
+func TestVersionedTreeRetention(t *testing.T) {
+	v := NewVersionedTree(2)
+
+	v.SetCIDRString("10.0.0.0/8", 1, false)
+	v1 := v.Commit()
+	v.DeleteCIDRString("10.0.0.0/8")
+	v.Commit()
+	v.SetCIDRString("192.168.0.0/16", 2, false)
+	v3 := v.Commit()
+
+	if _, err := v.FindAt(netip.MustParseAddr("10.1.1.1"), v1); err != ErrVersionCompacted {
+		t.Errorf("Expected ErrVersionCompacted, got %v", err)
+	}
+	if got, err := v.FindAt(netip.MustParseAddr("192.168.1.1"), v3); err != nil || got != 2 {
+		t.Errorf("Expected 2 at version %d, got %v (%v)", v3, got, err)
+	}
+
+	// the deleted prefix no longer needs a history
+	key, mask := prefixKeyMask(netip.MustParsePrefix("10.0.0.0/8"))
+	if node := v.hist.lookup6(key, mask); node != nil && node.value != nil {
+		t.Errorf("Expected history of deleted prefix to be compacted")
+	}
+}
@@ -0,0 +1,155 @@
+package nradix
+
+import (
+	"net/netip"
+	"time"
+)
+
+// EventType describes how a stored prefix changed.
+type EventType uint8
+
+const (
+	EventAdd EventType = iota + 1
+	EventUpdate
+	EventDelete
+)
+
+// String returns a readable name for the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventAdd:
+		return "add"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event describes a single change to a stored prefix.
+// Old is nil for EventAdd and New is nil for EventDelete.
+// Seq numbers every change made to the tree, starting at 1.
+// Expires is set when New was stored with a TTL, see SetWithTTL.
+type Event struct {
+	Seq     uint64
+	Type    EventType
+	Prefix  netip.Prefix
+	Old     interface{}
+	New     interface{}
+	Expires time.Time
+}
+
+// watcher is a callback registered with Watch, prefix is kept in its mapped form.
+type watcher struct {
+	prefix netip.Prefix
+	fn     func(Event)
+}
+
+// change is a pending notification collected while the tree is being modified.
+// new and expires are only set for changes that store a value.
+type change struct {
+	prefix  netip.Prefix
+	old     interface{}
+	new     interface{}
+	expires int64
+}
+
+// Watch registers fn to be called for every add, update or delete of a stored prefix inside prefix.
+// IPv4 prefixes live under ::ffff:0:0/96, so watching ::/0 reports changes of both families.
+// Callbacks run synchronously after the mutation has been applied, while the tree is still locked
+// for writing; they must not call back into the tree. The returned function removes the registration.
+func (tree *Tree) Watch(prefix netip.Prefix, fn func(Event)) (cancel func()) {
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+
+	w := &watcher{prefix: mappedPrefix(prefix), fn: fn}
+	tree.watchers = append(tree.watchers, w)
+
+	return func() {
+		tree.mutex.Lock()
+		defer tree.mutex.Unlock()
+		for i := range tree.watchers {
+			if tree.watchers[i] == w {
+				tree.watchers = append(tree.watchers[:i], tree.watchers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// newEvent builds the event numbered seq for a change, taking the arguments of notify.
+func newEvent(seq uint64, prefix netip.Prefix, old, new interface{}, expires int64) Event {
+	ev := Event{Seq: seq, Type: EventUpdate, Prefix: userPrefix(prefix), Old: old, New: new}
+	if expires != 0 {
+		ev.Expires = time.Unix(0, expires)
+	}
+	if old == nil {
+		ev.Type = EventAdd
+	} else if new == nil {
+		ev.Type = EventDelete
+	}
+	return ev
+}
+
+// logAhead passes the changes about to be made to the tree's journal, numbered as they will be announced.
+// The changes must not be made if it returns an error.
+func (tree *Tree) logAhead(changes ...change) error {
+	if tree.journal == nil {
+		return nil
+	}
+	evs := make([]Event, 0, len(changes))
+	seq := tree.seq
+	for _, c := range changes {
+		if c.old == nil && c.new == nil {
+			continue
+		}
+		seq++
+		evs = append(evs, newEvent(seq, c.prefix, c.old, c.new, c.expires))
+	}
+	if len(evs) == 0 {
+		return nil
+	}
+	return tree.journal(evs)
+}
+
+// notify announces a committed change of the value stored at prefix.
+// prefix is the node's stored prefix, old and new are nil when the prefix was absent or removed,
+// and expires is the expiry of new in unix nanoseconds, zero for none.
+func (tree *Tree) notify(prefix netip.Prefix, old, new interface{}, expires int64) {
+	if old == nil && new == nil {
+		return
+	}
+	tree.seq++
+	ev := newEvent(tree.seq, prefix, old, new, expires)
+
+	if tree.history != nil {
+		tree.recordHistory(ev)
+	}
+	tree.countPrefix(ev)
+	if tree.reverse != nil {
+		tree.reverse.update(ev)
+	}
+	for _, ix := range tree.labels {
+		ix.update(ev)
+	}
+
+	mapped := prefix.Masked()
+	for _, w := range tree.watchers {
+		if w.prefix.Bits() <= mapped.Bits() && w.prefix.Contains(mapped.Addr()) {
+			w.fn(ev)
+		}
+	}
+}
+
+// collectValues appends a change for every valued node in the subtree rooted at n.
+func collectValues(n *Node, changes []change) []change {
+	if n == nil {
+		return changes
+	}
+	if n.value != nil {
+		changes = append(changes, change{prefix: n.prefix, old: n.value})
+	}
+	changes = collectValues(n.left, changes)
+	return collectValues(n.right, changes)
+}
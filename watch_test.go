@@ -0,0 +1,67 @@
+package nradix
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestWatch tests that watchers receive events for prefixes inside the watched range only.
+func TestWatch(t *testing.T) {
+	tr := NewTree(0)
+
+	var events []Event
+	cancel := tr.Watch(netip.MustParsePrefix("10.0.0.0/8"), func(ev Event) {
+		events = append(events, ev)
+	})
+
+	tr.SetCIDRString("10.1.0.0/16", 1, false)
+	tr.SetCIDRString("10.1.0.0/16", 2, true)
+	tr.SetCIDRString("192.168.0.0/16", 3, false)
+	tr.SetCIDRString("2001:db8::/32", 4, false)
+	tr.SetCIDRString("10.1.2.0/24", 5, false)
+	tr.DeleteWholeRangeCIDR("10.1.0.0/16")
+
+	expected := []Event{
+		{Type: EventAdd, Prefix: netip.MustParsePrefix("10.1.0.0/16"), New: 1},
+		{Type: EventUpdate, Prefix: netip.MustParsePrefix("10.1.0.0/16"), Old: 1, New: 2},
+		{Type: EventAdd, Prefix: netip.MustParsePrefix("10.1.2.0/24"), New: 5},
+		{Type: EventDelete, Prefix: netip.MustParsePrefix("10.1.0.0/16"), Old: 2},
+		{Type: EventDelete, Prefix: netip.MustParsePrefix("10.1.2.0/24"), Old: 5},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		expected[i].Seq = events[i].Seq
+		if events[i] != expected[i] {
+			t.Errorf("Event %d: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	cancel()
+	tr.SetCIDRString("10.2.0.0/16", 6, false)
+	if len(events) != len(expected) {
+		t.Errorf("Received event after cancel: %+v", events[len(events)-1])
+	}
+}
+
+// TestWatchAll tests that watching ::/0 reports changes of both address families.
+func TestWatchAll(t *testing.T) {
+	tr := NewTree(0)
+
+	seen := map[netip.Prefix]EventType{}
+	tr.Watch(netip.MustParsePrefix("::/0"), func(ev Event) {
+		seen[ev.Prefix] = ev.Type
+	})
+
+	tr.SetCIDRString("192.168.1.0/24", 1, false)
+	tr.SetCIDRString("2001:db8::/32", 2, false)
+	tr.DeleteCIDRString("2001:db8::/32")
+
+	if seen[netip.MustParsePrefix("192.168.1.0/24")] != EventAdd {
+		t.Errorf("Missing add event for IPv4 prefix: %v", seen)
+	}
+	if seen[netip.MustParsePrefix("2001:db8::/32")] != EventDelete {
+		t.Errorf("Missing delete event for IPv6 prefix: %v", seen)
+	}
+}